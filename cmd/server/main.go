@@ -3,14 +3,17 @@ package main
 import (
 	"ebook-reader/internal/cache"
 	"ebook-reader/internal/downloader"
+	"ebook-reader/internal/purify"
 	"ebook-reader/internal/server"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"ebook-reader/static"
@@ -25,10 +28,67 @@ func envInt(key string, fallback int) int {
 	return fallback
 }
 
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// splitList 拆分逗号分隔的列表，忽略空项
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// parseCIDRs 解析逗号分隔的地址段，单个 IP 视为只含该地址的地址段
+func parseCIDRs(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range splitList(s) {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
 func main() {
 	port := flag.Int("p", envInt("PORT", 8080), "listen port (env: PORT)")
 	dataDir := flag.String("d", "data", "data directory for cache")
 	ttl := flag.Duration("ttl", 24*time.Hour, "cache TTL duration")
+	rulesFile := flag.String("rules", os.Getenv("PURIFY_RULES"), "TXT purification rules file, JSON (env: PURIFY_RULES)")
+	mdSplit := flag.Int("md-split", envInt("MD_SPLIT_LEVEL", 2), "split Markdown chapters on heading levels 1..N (env: MD_SPLIT_LEVEL)")
+	connectTimeout := flag.Duration("connect-timeout", envDuration("CONNECT_TIMEOUT", downloader.DefaultConnectTimeout), "download connect timeout, 0 = none (env: CONNECT_TIMEOUT)")
+	headerTimeout := flag.Duration("header-timeout", envDuration("HEADER_TIMEOUT", downloader.DefaultHeaderTimeout), "download response header timeout, 0 = none (env: HEADER_TIMEOUT)")
+	dlTimeout := flag.Duration("download-timeout", envDuration("DOWNLOAD_TIMEOUT", downloader.DefaultTimeout), "overall download timeout, 0 = none (env: DOWNLOAD_TIMEOUT)")
+	maxSize := flag.Int("max-size", envInt("MAX_SIZE_MB", downloader.DefaultMaxBytes>>20), "max download size in MiB, 0 = unlimited (env: MAX_SIZE_MB)")
+	allowTypes := flag.String("allow-types", os.Getenv("ALLOW_TYPES"), "comma-separated allowed download Content-Types, \"*\" = any, empty = built-in list (env: ALLOW_TYPES)")
+	allowSchemes := flag.String("allow-schemes", os.Getenv("ALLOW_SCHEMES"), "comma-separated URL schemes allowed for downloads, empty = http,https (env: ALLOW_SCHEMES)")
+	allowHosts := flag.String("allow-hosts", os.Getenv("ALLOW_HOSTS"), "comma-separated hosts allowed for downloads, \"*.example.com\" matches subdomains, empty = any (env: ALLOW_HOSTS)")
+	denyHosts := flag.String("deny-hosts", os.Getenv("DENY_HOSTS"), "comma-separated hosts denied for downloads (env: DENY_HOSTS)")
+	allowCIDRs := flag.String("allow-cidrs", os.Getenv("ALLOW_CIDRS"), "comma-separated address ranges allowed even if denied, e.g. a trusted LAN server (env: ALLOW_CIDRS)")
+	denyCIDRs := flag.String("deny-cidrs", os.Getenv("DENY_CIDRS"), "comma-separated address ranges denied in addition to loopback, private and link-local ranges (env: DENY_CIDRS)")
+	maxRedirects := flag.Int("max-redirects", envInt("MAX_REDIRECTS", downloader.DefaultMaxRedirects), "max redirects to follow, 0 = none (env: MAX_REDIRECTS)")
+	credFile := flag.String("credentials", os.Getenv("CREDENTIALS_FILE"), "per-host credentials, headers and cookies for downloads, JSON (env: CREDENTIALS_FILE)")
+	apiToken := flag.String("api-token", os.Getenv("API_TOKEN"), "bearer token for POST book API requests with one-off request headers, empty = disabled; prefer the env var (env: API_TOKEN)")
+	revalidate := flag.Duration("revalidate", envDuration("REVALIDATE_INTERVAL", 0), "recheck downloaded files with the source after this interval, 0 = only on &refresh=1 (env: REVALIDATE_INTERVAL)")
 	flag.Parse()
 
 	// 确保数据目录存在
@@ -37,15 +97,53 @@ func main() {
 	}
 
 	dl := downloader.New(*dataDir)
+	dl.ConnectTimeout = *connectTimeout
+	dl.HeaderTimeout = *headerTimeout
+	dl.Timeout = *dlTimeout
+	dl.MaxBytes = int64(*maxSize) << 20
+	dl.RevalidateAfter = *revalidate
+	dl.MaxRedirects = *maxRedirects
+	if *allowSchemes != "" {
+		dl.Egress.Schemes = splitList(*allowSchemes)
+	}
+	dl.Egress.AllowHosts = splitList(*allowHosts)
+	dl.Egress.DenyHosts = splitList(*denyHosts)
+	allow, err := parseCIDRs(*allowCIDRs)
+	if err != nil {
+		log.Fatalf("allow-cidrs: %v", err)
+	}
+	deny, err := parseCIDRs(*denyCIDRs)
+	if err != nil {
+		log.Fatalf("deny-cidrs: %v", err)
+	}
+	dl.Egress.AllowCIDRs = allow
+	dl.Egress.DenyCIDRs = append(dl.Egress.DenyCIDRs, deny...)
+	if dl.Credentials, err = downloader.LoadCredentials(*credFile); err != nil {
+		log.Fatalf("load credentials: %v", err)
+	}
+	switch *allowTypes {
+	case "":
+	case "*":
+		dl.AllowedTypes = nil
+	default:
+		dl.AllowedTypes = strings.Split(*allowTypes, ",")
+	}
 	c := cache.New(*dataDir, *ttl)
 
+	pur, err := purify.Load(*rulesFile)
+	if err != nil {
+		log.Fatalf("load purify rules: %v", err)
+	}
+
 	// 获取嵌入的静态文件
 	staticFS, err := fs.Sub(static.StaticFS, "dist")
 	if err != nil {
 		log.Fatalf("static fs: %v", err)
 	}
 
-	srv := server.New(dl, c, staticFS)
+	srv := server.New(dl, c, pur, staticFS)
+	srv.MarkdownSplitLevel = *mdSplit
+	srv.APIToken = *apiToken
 
 	addr := fmt.Sprintf(":%d", *port)
 	log.Printf("ebook-reader listening on %s", addr)
@@ -1,9 +1,12 @@
 package cache
 
 import (
+	"container/list"
 	"ebook-reader/internal/model"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -14,14 +17,22 @@ type Cache struct {
 	ttl     time.Duration
 
 	mu    sync.RWMutex
-	books map[string]*entry // key: url hash
+	books map[string]*entry // key: url hash，强制编码时为 hash@encoding
 }
 
 type entry struct {
 	book     *model.Book
 	lastUsed time.Time
+	// chapters 渲染后的章节变体（如简繁转换结果），key: variant/chapterID
+	chapters *chapterLRU
 }
 
+// 每本书缓存的章节变体上限，超出时淘汰最久未读的
+const (
+	maxChapterVariants = 32
+	maxChapterBytes    = 8 << 20
+)
+
 // New 创建缓存管理器
 func New(dataDir string, ttl time.Duration) *Cache {
 	c := &Cache{
@@ -57,6 +68,49 @@ func (c *Cache) Put(hash string, book *model.Book) {
 	c.mu.Unlock()
 }
 
+// GetChapter 获取缓存的章节变体，随书籍元数据一起过期
+func (c *Cache) GetChapter(key, variant string, chapterID int) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.books[key]
+	if !ok || e.chapters == nil {
+		return "", false
+	}
+	return e.chapters.get(chapterKey(variant, chapterID))
+}
+
+// PutChapter 缓存章节变体，书籍元数据不在缓存中时忽略。
+// 每本书最多保留 maxChapterVariants 个、共 maxChapterBytes 字节的变体
+func (c *Cache) PutChapter(key, variant string, chapterID int, content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.books[key]
+	if !ok {
+		return
+	}
+	if e.chapters == nil {
+		e.chapters = &chapterLRU{ll: list.New(), items: make(map[string]*list.Element)}
+	}
+	e.chapters.put(chapterKey(variant, chapterID), content)
+}
+
+func chapterKey(variant string, chapterID int) string {
+	return variant + "/" + strconv.Itoa(chapterID)
+}
+
+// InvalidateDir 移除缓存目录为 dir 或位于 dir 之下的书籍元数据及其章节变体，不删除磁盘文件。
+// 源文件更新后调用，下次访问时重新解析
+func (c *Cache) InvalidateDir(dir string) {
+	prefix := dir + string(filepath.Separator)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.books {
+		if e.book.CachePath == dir || strings.HasPrefix(e.book.CachePath, prefix) {
+			delete(c.books, key)
+		}
+	}
+}
+
 // DataDir 返回数据目录路径
 func (c *Cache) DataDir() string {
 	return c.dataDir
@@ -76,21 +130,76 @@ func (c *Cache) cleanup() {
 	var expired []string
 
 	c.mu.RLock()
-	for hash, e := range c.books {
+	for key, e := range c.books {
 		if now.Sub(e.lastUsed) > c.ttl {
-			expired = append(expired, hash)
+			expired = append(expired, key)
 		}
 	}
 	c.mu.RUnlock()
 
-	for _, hash := range expired {
+	for _, key := range expired {
 		c.mu.Lock()
 		// 二次检查，防止刚被访问
-		if e, ok := c.books[hash]; ok && now.Sub(e.lastUsed) > c.ttl {
-			delete(c.books, hash)
-			// 删除磁盘文件
-			os.RemoveAll(filepath.Join(c.dataDir, hash))
+		if e, ok := c.books[key]; ok && now.Sub(e.lastUsed) > c.ttl {
+			delete(c.books, key)
+			// 同一文件的不同编码变体共用缓存目录，全部过期后才删除磁盘文件
+			if !c.dirInUse(e.book.CachePath) {
+				os.RemoveAll(e.book.CachePath)
+			}
 		}
 		c.mu.Unlock()
 	}
 }
+
+// dirInUse 判断是否还有缓存条目引用该目录或其子目录（压缩包内的书位于 {hash}/entries/ 下），调用方需持有写锁
+func (c *Cache) dirInUse(dir string) bool {
+	prefix := dir + string(filepath.Separator)
+	for _, e := range c.books {
+		if e.book.CachePath == dir || strings.HasPrefix(e.book.CachePath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// chapterLRU 按最近使用淘汰的章节变体缓存，调用方需持有写锁
+type chapterLRU struct {
+	ll    *list.List // 元素为 *chapterItem，最近使用的在前
+	items map[string]*list.Element
+	size  int
+}
+
+type chapterItem struct {
+	key     string
+	content string
+}
+
+func (l *chapterLRU) get(key string) (string, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return "", false
+	}
+	l.ll.MoveToFront(el)
+	return el.Value.(*chapterItem).content, true
+}
+
+// put 存入变体，超出数量或字节上限时从最久未用的开始淘汰；单个变体超过字节上限时不缓存
+func (l *chapterLRU) put(key, content string) {
+	if el, ok := l.items[key]; ok {
+		l.remove(el)
+	}
+	if len(content) > maxChapterBytes {
+		return
+	}
+	l.items[key] = l.ll.PushFront(&chapterItem{key: key, content: content})
+	l.size += len(content)
+	for l.ll.Len() > maxChapterVariants || l.size > maxChapterBytes {
+		l.remove(l.ll.Back())
+	}
+}
+
+func (l *chapterLRU) remove(el *list.Element) {
+	it := l.ll.Remove(el).(*chapterItem)
+	delete(l.items, it.key)
+	l.size -= len(it.content)
+}
@@ -0,0 +1,208 @@
+package chconv
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// 词典来自 OpenCC，格式为每行 "原文\t候选1 候选2 ..."，取第一个候选
+//
+//go:embed dict/*.txt
+var dictFS embed.FS
+
+// 支持的转换方式
+const (
+	S2T  = "s2t"  // 简体 -> 繁体（OpenCC 标准）
+	T2S  = "t2s"  // 繁体 -> 简体
+	S2TW = "s2tw" // 简体 -> 台湾正体
+	S2HK = "s2hk" // 简体 -> 香港繁体
+)
+
+// chains 每种转换方式依次执行的词典组，组内按“词组优先、单字兜底”最长匹配
+var chains = map[string][][]string{
+	S2T:  {{"STPhrases", "STCharacters"}},
+	T2S:  {{"TSPhrases", "TSCharacters"}},
+	S2TW: {{"STPhrases", "STCharacters"}, {"TWVariants"}},
+	S2HK: {{"STPhrases", "STCharacters"}, {"HKVariants"}},
+}
+
+// dict 合并后的词典
+type dict struct {
+	m      map[string]string
+	maxLen int // 最长词条的字符数
+}
+
+// Converter 简繁转换器，并发安全
+type Converter struct {
+	mode  string
+	steps []*dict
+}
+
+var (
+	mu         sync.Mutex
+	converters = make(map[string]*Converter)
+	dicts      = make(map[string]*dict)
+)
+
+// Valid 判断转换方式是否受支持
+func Valid(mode string) bool {
+	_, ok := chains[mode]
+	return ok
+}
+
+// Get 返回指定转换方式的转换器，词典在首次使用时加载
+func Get(mode string) (*Converter, error) {
+	group, ok := chains[mode]
+	if !ok {
+		return nil, fmt.Errorf("unsupported conversion: %s", mode)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if c, ok := converters[mode]; ok {
+		return c, nil
+	}
+	c := &Converter{mode: mode}
+	for _, names := range group {
+		d, err := loadGroup(names)
+		if err != nil {
+			return nil, err
+		}
+		c.steps = append(c.steps, d)
+	}
+	converters[mode] = c
+	return c, nil
+}
+
+// loadGroup 加载并合并一组词典，靠前的词典优先，调用方需持有 mu
+func loadGroup(names []string) (*dict, error) {
+	key := strings.Join(names, "+")
+	if d, ok := dicts[key]; ok {
+		return d, nil
+	}
+	d := &dict{m: make(map[string]string)}
+	// 倒序加载，让靠前的词典覆盖靠后的
+	for i := len(names) - 1; i >= 0; i-- {
+		if err := loadDict(d, names[i]); err != nil {
+			return nil, err
+		}
+	}
+	dicts[key] = d
+	return d, nil
+}
+
+func loadDict(d *dict, name string) error {
+	f, err := dictFS.Open("dict/" + name + ".txt")
+	if err != nil {
+		return fmt.Errorf("open dict %s: %w", name, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// 个别词条用空格而非制表符分隔
+		from, to, ok := strings.Cut(line, "\t")
+		if !ok {
+			from, to, ok = strings.Cut(line, " ")
+		}
+		if !ok || from == "" {
+			continue
+		}
+		if i := strings.IndexByte(to, ' '); i >= 0 {
+			to = to[:i]
+		}
+		d.m[from] = to
+		d.maxLen = max(d.maxLen, utf8.RuneCountInString(from))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read dict %s: %w", name, err)
+	}
+	return nil
+}
+
+// Mode 返回转换方式
+func (c *Converter) Mode() string {
+	return c.mode
+}
+
+// Convert 转换纯文本
+func (c *Converter) Convert(s string) string {
+	for _, d := range c.steps {
+		s = d.convert(s)
+	}
+	return s
+}
+
+// ConvertHTML 只转换 HTML 标签之外的文本，避免改动属性中的资源路径
+func (c *Converter) ConvertHTML(s string) string {
+	var buf strings.Builder
+	buf.Grow(len(s))
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			buf.WriteString(c.Convert(s))
+			break
+		}
+		buf.WriteString(c.Convert(s[:lt]))
+		gt := strings.IndexByte(s[lt:], '>')
+		if gt < 0 {
+			buf.WriteString(s[lt:])
+			break
+		}
+		buf.WriteString(s[lt : lt+gt+1])
+		s = s[lt+gt+1:]
+	}
+	return buf.String()
+}
+
+// convert 正向最长匹配：从当前位置起尝试最长的词条，匹配不到则原样输出一个字符
+func (d *dict) convert(s string) string {
+	// 纯 ASCII 无需查词典
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	// 记录每个字符的字节偏移，子串切片查表不产生额外分配
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(s))
+	n := len(offsets) - 1
+
+	var buf strings.Builder
+	buf.Grow(len(s))
+	for i := 0; i < n; {
+		if s[offsets[i]] < utf8.RuneSelf {
+			buf.WriteByte(s[offsets[i]])
+			i++
+			continue
+		}
+		matched := false
+		for l := min(d.maxLen, n-i); l > 0; l-- {
+			if to, ok := d.m[s[offsets[i]:offsets[i+l]]]; ok {
+				buf.WriteString(to)
+				i += l
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			buf.WriteString(s[offsets[i]:offsets[i+1]])
+			i++
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,199 @@
+package downloader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// HostRule 发往某些主机的请求附加的认证信息、请求头和 Cookie，用于需要登录的源站
+type HostRule struct {
+	Hosts     []string          `json:"hosts"`               // 主机名，支持 "*.example.com" 匹配子域名
+	Username  string            `json:"username,omitempty"`  // Basic 认证
+	Password  string            `json:"password,omitempty"`  // Basic 认证
+	Token     string            `json:"token,omitempty"`     // Bearer token
+	Headers   map[string]string `json:"headers,omitempty"`   // 自定义请求头
+	Cookies   map[string]string `json:"cookies,omitempty"`   // Cookie，name -> value
+	UserAgent string            `json:"userAgent,omitempty"` // 覆盖默认 User-Agent
+}
+
+// Credentials 按主机附加认证信息的配置，按顺序取第一条匹配的规则。
+// 其中的密钥只用于发出的请求，不参与缓存路径的计算，也不会写入日志
+type Credentials struct {
+	UserAgent string     `json:"userAgent,omitempty"` // 默认 User-Agent，空则使用 Go 的默认值
+	Rules     []HostRule `json:"rules"`
+}
+
+// LoadCredentials 从 JSON 文件加载配置，path 为空时返回 nil
+func LoadCredentials(path string) (*Credentials, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read credentials: %w", err)
+	}
+	var c Credentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		// 不带原文返回，避免密钥出现在错误信息中
+		return nil, errors.New("parse credentials: invalid json")
+	}
+	for i, r := range c.Rules {
+		if len(r.Hosts) == 0 {
+			return nil, fmt.Errorf("credentials rule %d: no hosts", i)
+		}
+	}
+	return &c, nil
+}
+
+// match 返回主机对应的规则，没有时返回 nil；主机名不区分大小写
+func (c *Credentials) match(host string) *HostRule {
+	if c == nil {
+		return nil
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for i := range c.Rules {
+		if matchHost(c.Rules[i].Hosts, host) {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+// apply 按请求的主机附加规则中的认证信息、请求头、Cookie 和 User-Agent
+func (c *Credentials) apply(req *http.Request) {
+	if c == nil {
+		return
+	}
+	ua := c.UserAgent
+	if r := c.match(req.URL.Hostname()); r != nil {
+		if r.UserAgent != "" {
+			ua = r.UserAgent
+		}
+		if r.Username != "" || r.Password != "" {
+			req.SetBasicAuth(r.Username, r.Password)
+		}
+		if r.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.Token)
+		}
+		for k, v := range r.Headers {
+			req.Header.Set(k, v)
+		}
+		names := make([]string, 0, len(r.Cookies))
+		for name := range r.Cookies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			req.AddCookie(&http.Cookie{Name: name, Value: r.Cookies[name]})
+		}
+	}
+	if ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+}
+
+// strip 去掉主机规则附加的请求头
+func (c *Credentials) strip(req *http.Request, host string) {
+	r := c.match(host)
+	if r == nil {
+		return
+	}
+	if r.Username != "" || r.Password != "" || r.Token != "" {
+		req.Header.Del("Authorization")
+	}
+	if len(r.Cookies) > 0 {
+		req.Header.Del("Cookie")
+	}
+	for k := range r.Headers {
+		req.Header.Del(k)
+	}
+	if r.UserAgent != "" {
+		req.Header.Del("User-Agent")
+	}
+}
+
+// oneOffHeader 调用方随单次请求提供的请求头，只发给书籍所在的主机
+type oneOffHeader struct {
+	host   string
+	header http.Header
+	tag    string // 请求头的 HMAC，用于区分缓存
+}
+
+type headerKey struct{}
+
+// WithHeader 返回附加了一次性请求头的 ctx：下载 fileURL 时发送这些请求头，
+// 书中引用的其他主机的资源和重定向到其他主机的请求不发送。
+// 带请求头下载的内容按请求头的 HMAC 与匿名请求分开缓存（见 CacheKey），请求头本身不出现在缓存路径和日志中
+func (d *Downloader) WithHeader(ctx context.Context, fileURL string, header http.Header) context.Context {
+	if len(header) == 0 {
+		return ctx
+	}
+	u, err := neturl.Parse(fileURL)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, headerKey{}, &oneOffHeader{host: u.Host, header: header, tag: d.headerTag(header)})
+}
+
+// headerTag 以进程内随机密钥计算请求头集合的 HMAC
+func (d *Downloader) headerTag(header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, http.CanonicalHeaderKey(k))
+	}
+	sort.Strings(keys)
+	mac := hmac.New(sha256.New, d.secret)
+	for _, k := range keys {
+		mac.Write([]byte(k))
+		for _, v := range header.Values(k) {
+			mac.Write([]byte{0})
+			mac.Write([]byte(v))
+		}
+		mac.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// CacheKey 返回 url 在缓存中的键：通常为 URLHash(url)，ctx 带一次性请求头时混入请求头的 HMAC，
+// 使凭据下载的内容只能由持有相同请求头的请求取得
+func (d *Downloader) CacheKey(ctx context.Context, url string) string {
+	if h, ok := ctx.Value(headerKey{}).(*oneOffHeader); ok {
+		return URLHash(url + "#header=" + h.tag)
+	}
+	return URLHash(url)
+}
+
+// applyHeaders 为请求附加主机规则和一次性请求头
+func (d *Downloader) applyHeaders(req *http.Request) {
+	d.Credentials.apply(req)
+	if h, ok := req.Context().Value(headerKey{}).(*oneOffHeader); ok && strings.EqualFold(h.host, req.URL.Host) {
+		for k, v := range h.header {
+			req.Header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+}
+
+// redirectHeaders 重定向到其他主机时去掉原主机的认证信息和一次性请求头，按新主机重新附加，
+// 避免密钥随重定向发给其他主机
+func (d *Downloader) redirectHeaders(req, first *http.Request) {
+	if strings.EqualFold(req.URL.Host, first.URL.Host) {
+		return
+	}
+	d.Credentials.strip(req, first.URL.Hostname())
+	if h, ok := req.Context().Value(headerKey{}).(*oneOffHeader); ok {
+		for k := range h.header {
+			req.Header.Del(k)
+		}
+	}
+	d.applyHeaders(req)
+}
@@ -0,0 +1,24 @@
+package downloader
+
+import (
+	"ebook-reader/internal/parser"
+	"mime"
+)
+
+// detectExt 确定下载文件的扩展名：由解析器注册的识别规则按文件内容、文件名
+// （Content-Disposition 或 URL）和 Content-Type 判断，都识别不出时为 ".bin"
+func detectExt(filePath, contentType, name string) string {
+	if ext := parser.Detect(filePath, name, contentType); ext != "" {
+		return ext
+	}
+	return ".bin"
+}
+
+// dispositionName 返回 Content-Disposition 中的文件名，支持 RFC 5987 的 filename*
+func dispositionName(header string) string {
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
@@ -1,33 +1,98 @@
 package downloader
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"ebook-reader/internal/job"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Downloader HTTP 下载器，支持 singleflight 去重
 type Downloader struct {
 	dataDir  string
+	client   *http.Client
 	mu       sync.Mutex
 	inflight map[string]*call
+	secret   []byte // 计算一次性请求头 HMAC 的进程内随机密钥
+
+	// 下载限制，New 设置默认值，0 / nil 表示不限制
+	ConnectTimeout time.Duration // 建立 TCP 连接
+	HeaderTimeout  time.Duration // 发出请求到收到响应头
+	Timeout        time.Duration // 整个下载过程
+	MaxBytes       int64         // 文件大小上限，先按 Content-Length 检查，下载过程中再按实际字节数检查
+	AllowedTypes   []string      // 允许的 Content-Type，见 DefaultAllowedTypes
+	Egress         Policy        // 出站访问策略，见 DefaultPolicy
+	MaxRedirects   int           // 最多跟随的重定向次数，0 表示不跟随
+	Credentials    *Credentials  // 按主机附加的认证信息，nil 表示不附加
+
+	// RevalidateAfter 已下载的文件超过该时间后访问时向源站重新验证，0 表示只在强制刷新时验证
+	RevalidateAfter time.Duration
 }
 
+// call 一次共享的下载，所有等待者都取消后才中止
 type call struct {
-	wg  sync.WaitGroup
-	err error
+	done     chan struct{}
+	cancel   context.CancelFunc
+	waiters  int  // 仍在等待结果的请求数，受 Downloader.mu 保护
+	stopped  bool // 已因无人等待而取消，受 Downloader.mu 保护
+	filePath string
+	changed  bool // 内容为新下载的（而非重新验证后沿用）
+	err      error
+
+	// 以下受 Downloader.mu 保护
+	jobs     []*job.Job // 等待者关联的任务，共享同一份下载进度
+	received int64
+	total    int64
+}
+
+// report 把下载进度同步给所有等待者的任务
+func (d *Downloader) report(c *call, received, total int64) {
+	d.mu.Lock()
+	c.received, c.total = received, total
+	jobs := c.jobs
+	d.mu.Unlock()
+	for _, j := range jobs {
+		j.SetBytes(received, total)
+	}
+}
+
+type progressKey struct{}
+
+// reportProgress 上报 ctx 所属下载的进度
+func reportProgress(ctx context.Context, received, total int64) {
+	if fn, ok := ctx.Value(progressKey{}).(func(received, total int64)); ok {
+		fn(received, total)
+	}
 }
 
 // New 创建下载器
 func New(dataDir string) *Downloader {
-	return &Downloader{
-		dataDir:  dataDir,
-		inflight: make(map[string]*call),
+	d := &Downloader{
+		dataDir:        dataDir,
+		inflight:       make(map[string]*call),
+		ConnectTimeout: DefaultConnectTimeout,
+		HeaderTimeout:  DefaultHeaderTimeout,
+		Timeout:        DefaultTimeout,
+		MaxBytes:       DefaultMaxBytes,
+		AllowedTypes:   DefaultAllowedTypes(),
+		Egress:         DefaultPolicy(),
+		MaxRedirects:   DefaultMaxRedirects,
 	}
+	d.secret = make([]byte, 32)
+	rand.Read(d.secret)
+	d.client = d.newClient()
+	return d
 }
 
 // URLHash 计算 URL 的 sha256 hash
@@ -37,89 +102,275 @@ func URLHash(url string) string {
 }
 
 // Download 下载文件到缓存目录，返回本地文件路径和缓存目录
-// 如果已缓存则直接返回，并发请求同一 URL 只下载一次
-func (d *Downloader) Download(url string) (filePath string, cachePath string, err error) {
-	hash := URLHash(url)
+// 如果已缓存则直接返回，并发请求同一 URL 只下载一次。
+// 文件名为 raw + 识别出的扩展名，识别结果记录在同目录的 meta.json 中。
+// ctx 取消时本次调用立即返回，共享的下载在所有等待者都取消后才中止
+func (d *Downloader) Download(ctx context.Context, url string) (filePath string, cachePath string, err error) {
+	hash := d.CacheKey(ctx, url)
 	cachePath = filepath.Join(d.dataDir, hash)
-	filePath = filepath.Join(cachePath, "raw"+extFromURL(url))
 
 	// 已缓存，直接返回
-	if _, err := os.Stat(filePath); err == nil {
+	if _, filePath, ok := readMeta(cachePath); ok {
 		return filePath, cachePath, nil
 	}
 
-	// singleflight: 同一 URL 只下载一次
+	filePath, _, err = d.do(ctx, hash, func(ctx context.Context) (string, bool, error) {
+		return d.doDownload(ctx, url, cachePath, nil)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return filePath, cachePath, nil
+}
+
+// do 以 singleflight 方式执行同一 URL 的下载或重新验证：work 在独立的 goroutine 中进行，
+// 不随发起者的 ctx 取消，所有等待者都取消后才中止；等待者 ctx 关联的任务都会收到下载进度。
+// 返回文件路径以及内容是否为新下载的
+func (d *Downloader) do(ctx context.Context, hash string, work func(ctx context.Context) (string, bool, error)) (string, bool, error) {
 	d.mu.Lock()
-	if c, ok := d.inflight[hash]; ok {
-		d.mu.Unlock()
-		c.wg.Wait()
-		if c.err != nil {
-			return "", "", c.err
-		}
-		return filePath, cachePath, nil
+	c, ok := d.inflight[hash]
+	if !ok || c.stopped {
+		// 已取消的下载可能还在收尾，等它退出后再续传同一个 .part 文件
+		prev := c
+		dctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call{done: make(chan struct{}), cancel: cancel, total: -1}
+		d.inflight[hash] = c
+		dctx = context.WithValue(dctx, progressKey{}, func(received, total int64) {
+			d.report(c, received, total)
+		})
+		go func() {
+			if prev != nil {
+				<-prev.done
+			}
+			c.filePath, c.changed, c.err = runWork(dctx, work)
+			cancel()
+			d.mu.Lock()
+			if d.inflight[hash] == c {
+				delete(d.inflight, hash)
+			}
+			d.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	j := job.From(ctx)
+	if j != nil {
+		c.jobs = append(c.jobs, j)
 	}
-	c := &call{}
-	c.wg.Add(1)
-	d.inflight[hash] = c
+	received, total := c.received, c.total
 	d.mu.Unlock()
+	j.SetPhase(job.PhaseDownload)
+	j.SetBytes(received, total)
 
-	// 执行下载
-	c.err = d.doDownload(url, filePath, cachePath)
-	c.wg.Done()
+	select {
+	case <-c.done:
+		return c.filePath, c.changed, c.err
+	case <-ctx.Done():
+		d.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// 没有人再等待：中止下载，之后的请求重新发起并从 .part 续传
+			c.stopped = true
+			c.cancel()
+		}
+		d.mu.Unlock()
+		return "", false, ctx.Err()
+	}
+}
 
-	d.mu.Lock()
-	delete(d.inflight, hash)
-	d.mu.Unlock()
+// runWork 执行共享的下载，panic 转为错误：work 在独立的 goroutine 中运行，net/http 无法替它恢复
+func runWork(ctx context.Context, work func(ctx context.Context) (string, bool, error)) (filePath string, changed bool, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			filePath, changed, err = "", false, fmt.Errorf("panic: %v", x)
+		}
+	}()
+	return work(ctx)
+}
+
+// fileMeta 下载文件的元信息，完成后保存在缓存目录的 meta.json 中，
+// 下载过程中（不含 Ext）保存在 raw.part.json 中用于续传
+type fileMeta struct {
+	Ext          string `json:"ext,omitempty"`          // 识别出的扩展名，如 ".epub"
+	ContentType  string `json:"contentType,omitempty"`  // 响应的 Content-Type
+	Filename     string `json:"filename,omitempty"`     // Content-Disposition 中的文件名
+	ETag         string `json:"etag,omitempty"`         // 校验信息，续传时用于 If-Range
+	LastModified string `json:"lastModified,omitempty"` // 没有 ETag 时用于 If-Range
+	Size         int64  `json:"size"`                   // 完整文件大小，-1 表示源站未告知
+	// 以下只在 meta.json 中
+	SHA256    string    `json:"sha256,omitempty"` // 内容摘要，源站没有校验信息时据此判断内容是否变化
+	CheckedAt time.Time `json:"checkedAt"`        // 最近一次下载或重新验证的时间
+}
 
-	if c.err != nil {
-		return "", "", c.err
+const metaFile = "meta.json"
+
+// readMeta 读取 meta.json，返回元信息和已下载的文件路径
+func readMeta(cachePath string) (fileMeta, string, bool) {
+	var m fileMeta
+	data, err := os.ReadFile(filepath.Join(cachePath, metaFile))
+	if err != nil {
+		return m, "", false
 	}
-	return filePath, cachePath, nil
+	if json.Unmarshal(data, &m) != nil || m.Ext == "" || strings.ContainsAny(m.Ext, `/\`) {
+		return m, "", false
+	}
+	filePath := filepath.Join(cachePath, "raw"+m.Ext)
+	if _, err := os.Stat(filePath); err != nil {
+		return m, "", false
+	}
+	return m, filePath, true
 }
 
-func (d *Downloader) doDownload(url string, filePath string, cachePath string) error {
+// Revalidate 用条件请求（If-None-Match / If-Modified-Since）检查源文件是否有更新，
+// 有更新时下载新内容替换缓存的文件并返回 true；文件尚未下载时返回 false
+func (d *Downloader) Revalidate(ctx context.Context, url string) (bool, error) {
+	hash := d.CacheKey(ctx, url)
+	cachePath := filepath.Join(d.dataDir, hash)
+	m, _, ok := readMeta(cachePath)
+	if !ok {
+		return false, nil
+	}
+	_, changed, err := d.do(ctx, hash, func(ctx context.Context) (string, bool, error) {
+		return d.doDownload(ctx, url, cachePath, &m)
+	})
+	return changed, err
+}
+
+// RevalidateDue 已下载的文件距上次下载或验证是否超过了 RevalidateAfter
+func (d *Downloader) RevalidateDue(ctx context.Context, url string) bool {
+	if d.RevalidateAfter <= 0 {
+		return false
+	}
+	m, _, ok := readMeta(filepath.Join(d.dataDir, d.CacheKey(ctx, url)))
+	return ok && time.Since(m.CheckedAt) >= d.RevalidateAfter
+}
+
+// doDownload 下载文件，prev 非空时为重新验证：先发条件请求，未变化时沿用原文件。
+// 返回文件路径和内容是否有变化
+func (d *Downloader) doDownload(ctx context.Context, url string, cachePath string, prev *fileMeta) (string, bool, error) {
 	if err := os.MkdirAll(cachePath, 0755); err != nil {
-		return fmt.Errorf("mkdir cache: %w", err)
+		return "", false, fmt.Errorf("mkdir cache: %w", err)
+	}
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, d.Timeout, ErrTimeout)
+		defer cancel()
 	}
 
-	resp, err := http.Get(url)
+	// 先下载到 raw.part，中途断开时按记录的校验信息续传
+	partPath := filepath.Join(cachePath, partFile)
+	m := loadPartMeta(partPath)
+	for attempt := 0; ; attempt++ {
+		retry, err := d.fetchPart(ctx, url, partPath, &m, prev)
+		if errors.Is(err, errNotModified) {
+			return d.keep(cachePath, *prev, nil)
+		}
+		if err == nil {
+			break
+		}
+		if !retry || attempt >= maxResumeAttempts || ctx.Err() != nil {
+			return "", false, fmt.Errorf("download: %w", timeoutCause(ctx, err))
+		}
+	}
+
+	sum, err := fileSHA256(partPath)
 	if err != nil {
-		return fmt.Errorf("http get: %w", err)
+		return "", false, fmt.Errorf("hash: %w", err)
+	}
+	m.SHA256 = sum
+	m.CheckedAt = time.Now()
+	// 源站没有校验信息时每次都会返回完整内容，摘要相同则视为未变化
+	if prev != nil && prev.SHA256 == sum {
+		return d.keep(cachePath, *prev, &m)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http status: %d", resp.StatusCode)
+	// 下载完整后识别格式，再改名为 raw + 扩展名
+	name := m.Filename
+	if name == "" {
+		name = urlPath(url)
+	}
+	m.Ext = detectExt(partPath, m.ContentType, name)
+	filePath := filepath.Join(cachePath, "raw"+m.Ext)
+	if err := os.Rename(partPath, filePath); err != nil {
+		return "", false, fmt.Errorf("rename: %w", err)
+	}
+	os.Remove(partPath + ".json")
+	if err := writeJSON(filepath.Join(cachePath, metaFile), m); err != nil {
+		return "", false, fmt.Errorf("write meta: %w", err)
+	}
+	// 新内容识别出的格式不同时删除旧文件
+	if prev != nil && prev.Ext != m.Ext {
+		os.Remove(filepath.Join(cachePath, "raw"+prev.Ext))
+	}
+	return filePath, true, nil
+}
+
+// keep 重新验证后内容未变化：沿用原文件，更新验证时间和源站返回的新校验信息
+func (d *Downloader) keep(cachePath string, prev fileMeta, fresh *fileMeta) (string, bool, error) {
+	partPath := filepath.Join(cachePath, partFile)
+	os.Remove(partPath)
+	os.Remove(partPath + ".json")
+	if fresh != nil {
+		prev.ETag, prev.LastModified = fresh.ETag, fresh.LastModified
+	}
+	prev.CheckedAt = time.Now()
+	if err := writeJSON(filepath.Join(cachePath, metaFile), prev); err != nil {
+		return "", false, fmt.Errorf("write meta: %w", err)
 	}
+	return filepath.Join(cachePath, "raw"+prev.Ext), false, nil
+}
 
-	f, err := os.Create(filePath)
+// fileSHA256 计算文件内容的 sha256
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("create file: %w", err)
+		return "", err
 	}
 	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
 
-	if _, err := io.Copy(f, resp.Body); err != nil {
-		os.Remove(filePath)
-		return fmt.Errorf("download: %w", err)
+// timeoutCause 下载因整体超时中断时返回 ErrTimeout，否则原样返回 err
+func timeoutCause(ctx context.Context, err error) error {
+	if context.Cause(ctx) == ErrTimeout {
+		return ErrTimeout
 	}
-	return nil
+	return err
 }
 
-// extFromURL 从 URL 提取文件扩展名
-func extFromURL(url string) string {
-	// 去掉 query string
-	path := url
-	if idx := len(path) - 1; idx > 0 {
-		for i := len(path) - 1; i >= 0; i-- {
-			if path[i] == '?' {
-				path = path[:i]
-				break
-			}
-		}
+// urlPath 返回 URL 的路径部分，用于从中提取文件名
+func urlPath(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// maxResourceSize 书中引用的单个远程资源（图片等）大小上限
+const maxResourceSize = 20 << 20
+
+// FetchResource 下载书中引用的远程资源（如 Markdown / HTML 中的相对图片），返回内容
+func (d *Downloader) FetchResource(ctx context.Context, url string) ([]byte, error) {
+	resp, err := d.get(ctx, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResourceSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
 	}
-	ext := filepath.Ext(path)
-	if ext == "" {
-		ext = ".bin"
+	if len(data) > maxResourceSize {
+		return nil, fmt.Errorf("resource too large: %s", url)
 	}
-	return ext
+	return data, nil
 }
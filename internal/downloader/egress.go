@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxRedirects 默认最多跟随的重定向次数
+const DefaultMaxRedirects = 10
+
+// 违反出站访问策略时返回的错误，可用 errors.Is 判断
+var (
+	ErrForbidden        = errors.New("destination not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+)
+
+// Policy 出站访问策略，下载书籍和书中引用的远程资源都受其约束。
+// 协议和主机名在发起请求及每次重定向前检查；IP 段在 DNS 解析之后、建立连接之前按实际连接的地址检查，
+// 因此解析到内网的域名和重定向到内网的地址同样会被拒绝。下载不经过 HTTP(S)_PROXY，检查的始终是源站的地址
+type Policy struct {
+	Schemes    []string       // 允许的协议，空表示不限
+	AllowHosts []string       // 非空时只允许这些主机，支持 "*.example.com" 匹配子域名
+	DenyHosts  []string       // 拒绝的主机，优先于 AllowHosts
+	AllowCIDRs []netip.Prefix // 允许的地址段，优先于 DenyCIDRs，用于放行内网中可信的源站
+	DenyCIDRs  []netip.Prefix // 拒绝的地址段，见 DefaultDenyCIDRs
+}
+
+// DefaultPolicy 默认策略：只允许 http / https，拒绝 DefaultDenyCIDRs 中的地址
+func DefaultPolicy() Policy {
+	return Policy{
+		Schemes:   []string{"http", "https"},
+		DenyCIDRs: DefaultDenyCIDRs(),
+	}
+}
+
+// DefaultDenyCIDRs 默认拒绝的地址段：本机、内网、链路本地（含云厂商元数据服务 169.254.169.254）、
+// 运营商级 NAT、组播及保留地址，以及内嵌 IPv4 地址的 NAT64 / 6to4 地址段
+func DefaultDenyCIDRs() []netip.Prefix {
+	cidrs := []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "64:ff9b::/96", "2002::/16", "fc00::/7", "fe80::/10", "ff00::/8",
+	}
+	prefixes := make([]netip.Prefix, len(cidrs))
+	for i, c := range cidrs {
+		prefixes[i] = netip.MustParsePrefix(c)
+	}
+	return prefixes
+}
+
+// checkURL 检查请求地址的协议和主机名
+func (p *Policy) checkURL(u *url.URL) error {
+	if len(p.Schemes) > 0 && !containsFold(p.Schemes, u.Scheme) {
+		return fmt.Errorf("%w: scheme %s", ErrForbidden, u.Scheme)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if matchHost(p.DenyHosts, host) || len(p.AllowHosts) > 0 && !matchHost(p.AllowHosts, host) {
+		return fmt.Errorf("%w: host %s", ErrForbidden, host)
+	}
+	return nil
+}
+
+// checkAddr 检查即将连接的 "ip:port"
+func (p *Policy) checkAddr(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbidden, address)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbidden, address)
+	}
+	ip = ip.Unmap().WithZone("")
+	for _, c := range p.AllowCIDRs {
+		if c.Contains(ip) {
+			return nil
+		}
+	}
+	for _, c := range p.DenyCIDRs {
+		if c.Contains(ip) {
+			return fmt.Errorf("%w: address %s", ErrForbidden, ip)
+		}
+	}
+	return nil
+}
+
+// matchHost 主机名是否匹配列表中的某一项，"*.example.com" 匹配其任意子域名，"*" 匹配所有主机
+func matchHost(patterns []string, host string) bool {
+	for _, pat := range patterns {
+		pat = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pat)), ".")
+		switch {
+		case pat == "*" || pat == host:
+			return true
+		case strings.HasPrefix(pat, "*.") && strings.HasSuffix(host, pat[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheckURL(t *testing.T) {
+	p := Policy{
+		Schemes:    []string{"http", "https"},
+		AllowHosts: []string{"*.example.com", "books.org"},
+		DenyHosts:  []string{"admin.example.com"},
+	}
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"http://books.org/a.epub", true},
+		{"HTTPS://BOOKS.ORG/a.epub", true},
+		{"http://books.org./a.epub", true},
+		{"http://cdn.example.com/a.epub", true},
+		{"http://a.b.example.com/a.epub", true},
+		{"http://example.com/a.epub", false},
+		{"http://admin.example.com/a.epub", false},
+		{"http://ADMIN.Example.com/a.epub", false},
+		{"http://evil.org/a.epub", false},
+		{"http://notexample.com/a.epub", false},
+		{"ftp://books.org/a.epub", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.checkURL(u)
+		if got := err == nil; got != tt.allowed {
+			t.Errorf("checkURL(%s) = %v, want allowed=%v", tt.url, err, tt.allowed)
+		}
+		if err != nil && !errors.Is(err, ErrForbidden) {
+			t.Errorf("checkURL(%s) error %v is not ErrForbidden", tt.url, err)
+		}
+	}
+}
+
+func TestPolicyCheckAddr(t *testing.T) {
+	p := DefaultPolicy()
+	p.AllowCIDRs = []netip.Prefix{netip.MustParsePrefix("10.1.2.0/24")}
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"93.184.216.34:80", true},
+		{"[2606:2800:220:1::1]:443", true},
+		{"127.0.0.1:80", false},
+		{"10.0.0.1:80", false},
+		{"10.1.2.3:80", true}, // AllowCIDRs 优先
+		{"172.16.5.4:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"100.64.0.1:80", false},
+		{"0.0.0.0:80", false},
+		{"[::1]:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[::ffff:169.254.169.254]:80", false},
+		{"[::ffff:10.1.2.3]:80", true},
+		{"[64:ff9b::7f00:1]:80", false},
+		{"[2002:7f00:1::]:80", false},
+		{"[fd00::1]:80", false},
+		{"[fe80::1%eth0]:80", false},
+		{"localhost:80", false}, // 不是 IP，拒绝
+		{"garbage", false},
+	}
+	for _, tt := range tests {
+		err := p.checkAddr(tt.addr)
+		if got := err == nil; got != tt.allowed {
+			t.Errorf("checkAddr(%s) = %v, want allowed=%v", tt.addr, err, tt.allowed)
+		}
+		if err != nil && !errors.Is(err, ErrForbidden) {
+			t.Errorf("checkAddr(%s) error %v is not ErrForbidden", tt.addr, err)
+		}
+	}
+}
+
+func TestPolicyRedirects(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/book.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		case "/to-book":
+			http.Redirect(w, r, "/book.txt", http.StatusFound)
+		case "/to-denied-host":
+			http.Redirect(w, r, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/book.txt", http.StatusFound)
+		case "/to-ftp":
+			http.Redirect(w, r, "ftp://127.0.0.1/book.txt", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		allowLoop bool // 放行测试服务器所在的回环地址
+		want      error
+	}{
+		{"direct loopback denied", "/book.txt", false, ErrForbidden},
+		{"allowed", "/book.txt", true, nil},
+		{"redirect allowed", "/to-book", true, nil},
+		{"redirect to denied host", "/to-denied-host", true, ErrForbidden},
+		{"redirect to denied scheme", "/to-ftp", true, ErrForbidden},
+		{"too many redirects", "/loop", true, ErrTooManyRedirects},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(t.TempDir())
+			d.Egress.DenyHosts = []string{"localhost"}
+			d.MaxRedirects = 3
+			if tt.allowLoop {
+				d.Egress.AllowCIDRs = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+			}
+			_, _, err := d.Download(context.Background(), srv.URL+tt.path)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Download: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Download error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
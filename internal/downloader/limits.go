@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"context"
+	"ebook-reader/internal/parser"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 下载限制的默认值
+const (
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultHeaderTimeout  = 30 * time.Second
+	DefaultTimeout        = 10 * time.Minute
+	DefaultMaxBytes       = 512 << 20
+)
+
+// 违反下载限制时返回的错误，可用 errors.Is 判断
+var (
+	ErrConnectTimeout = errors.New("connect timeout")
+	ErrHeaderTimeout  = errors.New("response header timeout")
+	ErrTimeout        = errors.New("download timeout")
+	ErrTooLarge       = errors.New("file too large")
+	ErrContentType    = errors.New("content type not allowed")
+)
+
+// DefaultAllowedTypes 默认允许的 Content-Type：已注册格式的 MIME 类型，
+// 以及源站常用的通用二进制类型和任意文本（格式另按文件内容识别）
+func DefaultAllowedTypes() []string {
+	types := []string{"application/octet-stream", "binary/octet-stream", "application/x-download", "text/*"}
+	for _, f := range parser.Formats() {
+		types = append(types, f.MIMETypes...)
+	}
+	return types
+}
+
+// newClient 创建下载用的 HTTP 客户端，连接超时在建立 TCP 连接时按 d.ConnectTimeout 生效；
+// 直接连接源站，不读取代理环境变量；每次连接前按 d.Egress 检查解析出的地址，每次重定向前检查目标地址和次数，并按新主机调整认证信息
+func (d *Downloader) newClient() *http.Client {
+	dialer := &net.Dialer{
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return d.Egress.checkAddr(address)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 不使用 HTTP(S)_PROXY：经代理连接时只能检查代理的地址，目标地址由代理代为访问，会绕过出站策略
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if d.ConnectTimeout <= 0 {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		dctx, cancel := context.WithTimeoutCause(ctx, d.ConnectTimeout, ErrConnectTimeout)
+		defer cancel()
+		conn, err := dialer.DialContext(dctx, network, addr)
+		if err != nil && context.Cause(dctx) == ErrConnectTimeout {
+			return nil, ErrConnectTimeout
+		}
+		return conn, err
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > d.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			if err := d.Egress.checkURL(req.URL); err != nil {
+				return err
+			}
+			d.redirectHeaders(req, via[0])
+			return nil
+		},
+	}
+}
+
+// get 发起 GET 请求，按主机附加认证信息后再附加 header；响应头须在 d.HeaderTimeout 内到达，响应体关闭时释放计时器
+func (d *Downloader) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	hctx, cancel := context.WithCancelCause(ctx)
+	var timer *time.Timer
+	if d.HeaderTimeout > 0 {
+		timer = time.AfterFunc(d.HeaderTimeout, func() { cancel(ErrHeaderTimeout) })
+	}
+	req, err := http.NewRequestWithContext(hctx, http.MethodGet, url, nil)
+	if err == nil {
+		err = d.Egress.checkURL(req.URL)
+	}
+	if err != nil {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel(nil)
+		return nil, err
+	}
+	d.applyHeaders(req)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := d.client.Do(req)
+	if timer != nil && !timer.Stop() {
+		// 计时器已触发：即使刚好收到响应头，请求也已被取消
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel(nil)
+		return nil, ErrHeaderTimeout
+	}
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody 关闭响应体时取消对应的请求 ctx
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
+// typeAllowed 按 AllowedTypes 检查 Content-Type，支持 "text/*" 与 "*" 通配；
+// 没有 Content-Type 时放行，由文件内容识别格式
+func (d *Downloader) typeAllowed(contentType string) bool {
+	if d.AllowedTypes == nil || contentType == "" {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range d.AllowedTypes {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == "*" || a == "*/*" || a == mt || strings.HasSuffix(a, "/*") && strings.HasPrefix(mt, a[:len(a)-1]) {
+			return true
+		}
+	}
+	return false
+}
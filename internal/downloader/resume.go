@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	partFile = "raw.part"
+	// maxResumeAttempts 一次下载中连接断开后最多续传的次数
+	maxResumeAttempts = 3
+)
+
+var (
+	// errIncomplete 收到的字节数与源站声明的大小不符
+	errIncomplete = errors.New("incomplete download")
+	// errNotModified 重新验证时源站返回 304
+	errNotModified = errors.New("not modified")
+)
+
+// loadPartMeta 读取上次中断的下载留下的校验信息，没有时返回空记录
+func loadPartMeta(partPath string) fileMeta {
+	m := fileMeta{Size: -1}
+	if data, err := os.ReadFile(partPath + ".json"); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	return m
+}
+
+// fetchPart 把响应写入 .part 文件：已有部分内容且记录了 ETag / Last-Modified 时用 Range 续传，
+// 源站不支持续传或内容已变化时从头下载；prev 非空且没有可续传的部分时发条件请求，未变化返回 errNotModified。
+// 返回的 retry 表示中途断开，可以再次续传
+func (d *Downloader) fetchPart(ctx context.Context, url, partPath string, m *fileMeta, prev *fileMeta) (retry bool, err error) {
+	var offset int64
+	header := http.Header{}
+	if fi, err := os.Stat(partPath); err == nil && fi.Size() > 0 {
+		validator := m.ETag
+		if validator == "" || strings.HasPrefix(validator, "W/") {
+			// 弱 ETag 不能用于 If-Range
+			validator = m.LastModified
+		}
+		if validator != "" {
+			offset = fi.Size()
+			header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			header.Set("If-Range", validator)
+		}
+	}
+	if offset == 0 && prev != nil {
+		if prev.ETag != "" {
+			header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	resp, err := d.get(ctx, url, header)
+	if err != nil {
+		return false, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if prev != nil {
+			return false, errNotModified
+		}
+		return false, fmt.Errorf("http status: %d", resp.StatusCode)
+	case http.StatusPartialContent:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			// 续传位置对不上，丢弃已下载的部分重来
+			os.Remove(partPath)
+			*m = fileMeta{Size: -1}
+			return true, errors.New("bad content range")
+		}
+		m.Size = total
+	case http.StatusOK:
+		// 首次下载，或 If-Range 校验失败（源文件已变化）
+		offset = 0
+		*m = fileMeta{
+			ContentType:  resp.Header.Get("Content-Type"),
+			Filename:     dispositionName(resp.Header.Get("Content-Disposition")),
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Size:         resp.ContentLength,
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		os.Remove(partPath)
+		*m = fileMeta{Size: -1}
+		return true, errors.New("range not satisfiable")
+	default:
+		return false, fmt.Errorf("http status: %d", resp.StatusCode)
+	}
+
+	if !d.typeAllowed(m.ContentType) {
+		return false, fmt.Errorf("%w: %s", ErrContentType, m.ContentType)
+	}
+	if d.MaxBytes > 0 && m.Size > d.MaxBytes {
+		return false, fmt.Errorf("%w: %d bytes", ErrTooLarge, m.Size)
+	}
+	// 先记录校验信息，进程中途退出后也能续传
+	if err := writeJSON(partPath+".json", m); err != nil {
+		return false, fmt.Errorf("write part meta: %w", err)
+	}
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flag = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(partPath, flag, 0644)
+	if err != nil {
+		return false, fmt.Errorf("create file: %w", err)
+	}
+	var body io.Reader = resp.Body
+	if d.MaxBytes > 0 {
+		// 多读一个字节用于判断是否超限（Content-Length 缺失或不实时）
+		body = io.LimitReader(resp.Body, d.MaxBytes-offset+1)
+	}
+	n, err := io.Copy(&progressWriter{w: f, ctx: ctx, n: offset, total: m.Size}, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case err != nil:
+		// 连接中断可以续传，取消、超时不重试
+		return ctx.Err() == nil, err
+	case d.MaxBytes > 0 && offset+n > d.MaxBytes:
+		os.Remove(partPath)
+		return false, ErrTooLarge
+	case m.Size >= 0 && offset+n != m.Size:
+		return true, fmt.Errorf("%w: got %d of %d bytes", errIncomplete, offset+n, m.Size)
+	}
+	return false, nil
+}
+
+// progressWriter 写入 .part 文件的同时上报已下载的字节数
+type progressWriter struct {
+	w     io.Writer
+	ctx   context.Context
+	n     int64
+	total int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.n += int64(n)
+	reportProgress(pw.ctx, pw.n, pw.total)
+	return n, err
+}
+
+// parseContentRange 解析 "bytes start-end/total"，total 为 * 时返回 -1
+func parseContentRange(v string) (start, total int64, ok bool) {
+	v, found := strings.CutPrefix(v, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rng, size, found := strings.Cut(v, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+// writeJSON 先写临时文件再改名，避免进程中途退出留下半个文件
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
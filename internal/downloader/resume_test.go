@@ -0,0 +1,183 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDownloader 返回放行回环地址的下载器，供 httptest 服务器使用
+func newTestDownloader(t *testing.T) *Downloader {
+	d := New(t.TempDir())
+	d.Egress.AllowCIDRs = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	return d
+}
+
+func TestFetchPartResume(t *testing.T) {
+	body := []byte(strings.Repeat("0123456789", 100))
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	lastModified := modTime.Format(http.TimeFormat)
+	changed := bytes.ToUpper(bytes.Repeat([]byte("abcdefghij"), 50))
+
+	tests := []struct {
+		name        string
+		part        []byte   // 上次中断时已下载的内容
+		partMeta    fileMeta // 上次记录的校验信息
+		serverETag  string
+		serverBody  []byte
+		wantIfRange string // 期望请求携带的 If-Range，空表示不应续传
+		want        []byte
+	}{
+		{
+			name:        "resume with strong etag",
+			part:        body[:300],
+			partMeta:    fileMeta{ETag: `"v1"`, Size: int64(len(body))},
+			serverETag:  `"v1"`,
+			serverBody:  body,
+			wantIfRange: `"v1"`,
+			want:        body,
+		},
+		{
+			name:        "weak etag falls back to last-modified",
+			part:        body[:500],
+			partMeta:    fileMeta{ETag: `W/"v1"`, LastModified: lastModified, Size: int64(len(body))},
+			serverETag:  `W/"v1"`,
+			serverBody:  body,
+			wantIfRange: lastModified,
+			want:        body,
+		},
+		{
+			name:        "validator changed restarts from scratch",
+			part:        body[:300],
+			partMeta:    fileMeta{ETag: `"v1"`, Size: int64(len(body))},
+			serverETag:  `"v2"`,
+			serverBody:  changed,
+			wantIfRange: `"v1"`,
+			want:        changed,
+		},
+		{
+			name:       "no validator restarts from scratch",
+			part:       body[:300],
+			partMeta:   fileMeta{Size: int64(len(body))},
+			serverETag: `"v1"`,
+			serverBody: body,
+			want:       body,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotRange, gotIfRange string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotRange, gotIfRange = r.Header.Get("Range"), r.Header.Get("If-Range")
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("ETag", tt.serverETag)
+				http.ServeContent(w, r, "book.txt", modTime, bytes.NewReader(tt.serverBody))
+			}))
+			defer srv.Close()
+
+			d := newTestDownloader(t)
+			partPath := filepath.Join(t.TempDir(), partFile)
+			if err := os.WriteFile(partPath, tt.part, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeJSON(partPath+".json", tt.partMeta); err != nil {
+				t.Fatal(err)
+			}
+
+			m := loadPartMeta(partPath)
+			if _, err := d.fetchPart(context.Background(), srv.URL+"/book.txt", partPath, &m, nil); err != nil {
+				t.Fatalf("fetchPart: %v", err)
+			}
+			if gotIfRange != tt.wantIfRange {
+				t.Errorf("If-Range = %q, want %q", gotIfRange, tt.wantIfRange)
+			}
+			if wantRange := tt.wantIfRange != ""; (gotRange != "") != wantRange {
+				t.Errorf("Range = %q, want resume=%v", gotRange, wantRange)
+			}
+			got, err := os.ReadFile(partPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("part content = %d bytes %q..., want %d bytes %q...", len(got), got[:min(len(got), 20)], len(tt.want), tt.want[:20])
+			}
+			if m.ETag != tt.serverETag {
+				t.Errorf("meta ETag = %q, want %q", m.ETag, tt.serverETag)
+			}
+		})
+	}
+}
+
+func TestFetchPartBadContentRange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 返回的起始位置与请求的不符
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Range", "bytes 0-9/10")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t)
+	partPath := filepath.Join(t.TempDir(), partFile)
+	os.WriteFile(partPath, []byte("01234"), 0644)
+	m := fileMeta{ETag: `"v1"`, Size: 10}
+	retry, err := d.fetchPart(context.Background(), srv.URL, partPath, &m, nil)
+	if err == nil || !retry {
+		t.Fatalf("fetchPart = (%v, %v), want retryable error", retry, err)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Errorf("part file should be discarded, stat err = %v", err)
+	}
+}
+
+func TestDownloadResumesAfterDisconnect(t *testing.T) {
+	body := []byte(strings.Repeat("0123456789", 1000))
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		first := len(ranges) == 1
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		if first {
+			// 只发出一半内容就断开连接
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body[:len(body)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "book.txt", modTime, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t)
+	filePath, _, err := d.Download(context.Background(), srv.URL+"/book.txt")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	got, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("downloaded %d bytes, want %d", len(got), len(body))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 2 || ranges[1] != "bytes="+strconv.Itoa(len(body)/2)+"-" {
+		t.Errorf("requests Range = %q, want a second request resuming at %d", ranges, len(body)/2)
+	}
+}
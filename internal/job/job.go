@@ -0,0 +1,257 @@
+package job
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 任务阶段
+const (
+	PhaseQueued   = "queued"   // 已创建，尚未开始下载
+	PhaseDownload = "download" // 下载源文件
+	PhaseExtract  = "extract"  // 解压压缩包
+	PhaseParse    = "parse"    // 解析书籍
+	PhaseDone     = "done"     // 完成
+	PhaseError    = "error"    // 失败，见 Job.Err
+)
+
+// ErrAbandoned 所有订阅者离开超过设定时间，任务被取消
+var ErrAbandoned = errors.New("job abandoned")
+
+// Progress 任务进度快照
+type Progress struct {
+	Phase    string `json:"phase"`
+	Received int64  `json:"received"` // 当前阶段已处理的字节数
+	Total    int64  `json:"total"`    // 当前阶段的总字节数，-1 表示未知
+}
+
+// Job 一次加载书籍（下载 + 解压 + 解析）的任务，所有等待同一本书的请求共享。
+// 更新进度的方法对 nil 安全，未关联任务的调用方无需判断
+type Job struct {
+	ID string
+
+	mu      sync.Mutex
+	p       Progress
+	err     error
+	changed chan struct{} // 进度变化时关闭并替换
+	done    chan struct{}
+
+	cancel    context.CancelCauseFunc
+	subs      int           // 订阅者数，见 Hold
+	idleAfter time.Duration // 无订阅者持续该时间后取消，0 表示不取消
+	idle      *time.Timer
+}
+
+func newJob() *Job {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &Job{
+		ID:      hex.EncodeToString(b),
+		p:       Progress{Phase: PhaseQueued, Total: -1},
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Watch 返回当前进度和一个在进度下次变化时关闭的 channel
+func (j *Job) Watch() (Progress, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.p, j.changed
+}
+
+// Done 任务结束（完成或失败）时关闭
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Err 任务失败的原因，未结束或成功时为 nil
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Hold 登记一个等待任务结果的订阅者，订阅者离开时调用返回的 release。
+// 所有订阅者离开超过 Registry 设定的空闲时间且无人再订阅时，任务以 ErrAbandoned 取消
+func (j *Job) Hold() (release func()) {
+	j.mu.Lock()
+	j.subs++
+	if j.idle != nil {
+		j.idle.Stop()
+	}
+	j.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			if j.subs--; j.subs == 0 {
+				j.armIdle()
+			}
+		})
+	}
+}
+
+// armIdle 开始空闲计时，调用方持有 j.mu
+func (j *Job) armIdle() {
+	if j.idleAfter <= 0 || j.cancel == nil {
+		return
+	}
+	if j.idle == nil {
+		j.idle = time.AfterFunc(j.idleAfter, func() { j.cancel(ErrAbandoned) })
+		return
+	}
+	j.idle.Reset(j.idleAfter)
+}
+
+// SetPhase 进入新阶段，字节计数清零
+func (j *Job) SetPhase(phase string) {
+	j.update(func(p *Progress) bool {
+		if p.Phase == phase {
+			return false
+		}
+		*p = Progress{Phase: phase, Total: -1}
+		return true
+	})
+}
+
+// SetBytes 更新当前阶段的字节进度，total 为 -1 表示未知
+func (j *Job) SetBytes(received, total int64) {
+	j.update(func(p *Progress) bool {
+		p.Received, p.Total = received, total
+		return true
+	})
+}
+
+// AddBytes 累加当前阶段已处理的字节数
+func (j *Job) AddBytes(n int64) {
+	j.update(func(p *Progress) bool {
+		p.Received += n
+		return n != 0
+	})
+}
+
+// update 修改进度并通知等待者，任务结束后忽略
+func (j *Job) update(fn func(p *Progress) bool) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.p.Phase == PhaseDone || j.p.Phase == PhaseError || !fn(&j.p) {
+		return
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// finish 结束任务
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	if j.idle != nil {
+		j.idle.Stop()
+	}
+	j.err = err
+	j.p = Progress{Phase: PhaseDone, Total: -1}
+	if err != nil {
+		j.p.Phase = PhaseError
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+	j.mu.Unlock()
+	close(j.done)
+}
+
+type ctxKey struct{}
+
+// With 返回关联了任务的 ctx，下载和解析过程经由它上报进度
+func With(ctx context.Context, j *Job) context.Context {
+	return context.WithValue(ctx, ctxKey{}, j)
+}
+
+// From 取出 ctx 关联的任务，没有时返回 nil
+func From(ctx context.Context) *Job {
+	j, _ := ctx.Value(ctxKey{}).(*Job)
+	return j
+}
+
+// Registry 按键去重的任务表：同一个键同时只有一个进行中的任务，
+// 结束的任务再保留一段时间，供稍后才订阅进度的客户端取得结果
+type Registry struct {
+	mu     sync.Mutex
+	byKey  map[string]*Job
+	byID   map[string]*Job
+	retain time.Duration
+	idle   time.Duration
+}
+
+// NewRegistry 创建任务表，结束的任务保留 retain 后删除；
+// 进行中的任务无订阅者（见 Job.Hold）持续 idle 后取消，idle 为 0 时不取消
+func NewRegistry(retain, idle time.Duration) *Registry {
+	return &Registry{
+		byKey:  make(map[string]*Job),
+		byID:   make(map[string]*Job),
+		retain: retain,
+		idle:   idle,
+	}
+}
+
+// Start 返回键对应的进行中任务，没有则新建并在后台执行 fn。
+// fn 的 ctx 不随单个请求取消，只在无人订阅超过空闲时间后取消，已关联该任务。
+// 新建的任务立即开始空闲计时，调用方应随即 Hold
+func (r *Registry) Start(key string, fn func(ctx context.Context) error) *Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if j, ok := r.byKey[key]; ok {
+		return j
+	}
+	j := newJob()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	j.cancel, j.idleAfter = cancel, r.idle
+	j.mu.Lock()
+	j.armIdle()
+	j.mu.Unlock()
+	r.byKey[key] = j
+	r.byID[j.ID] = j
+	go func() {
+		err := run(With(ctx, j), fn)
+		if err != nil && errors.Is(context.Cause(ctx), ErrAbandoned) {
+			err = ErrAbandoned
+		}
+		cancel(nil)
+		r.mu.Lock()
+		delete(r.byKey, key)
+		r.mu.Unlock()
+		j.finish(err)
+		time.AfterFunc(r.retain, func() {
+			r.mu.Lock()
+			delete(r.byID, j.ID)
+			r.mu.Unlock()
+		})
+	}()
+	return j
+}
+
+// run 执行任务，fn 中的 panic（如解析器遇到损坏的文件）转为错误，不影响整个服务
+func run(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("panic: %v", x)
+		}
+	}()
+	return fn(ctx)
+}
+
+// Get 按 ID 查找任务
+func (r *Registry) Get(id string) (*Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.byID[id]
+	return j, ok
+}
@@ -2,21 +2,32 @@ package model
 
 // Book 书籍元数据，解析后缓存在内存中
 type Book struct {
-	ID       string    `json:"id"` // URL 的 sha256 hash
-	Title    string    `json:"title"`
-	Author   string    `json:"author"`
-	Format   string    `json:"format"`   // "epub" / "txt"
-	CoverURL string    `json:"coverUrl"` // /api/book/cover?file=...
-	Chapters []Chapter `json:"chapters"`
+	ID       string `json:"id"` // URL 的 sha256 hash
+	Title    string `json:"title"`
+	Author   string `json:"author"`
+	Format   string `json:"format"`             // "epub" / "txt" / "fb2" / "mobi" / "comic" / "pdf" / "docx" / "odt" / "md" / "html" / "htmlz" / "umd" / "rtf" / "collection"
+	Encoding string `json:"encoding,omitempty"` // TXT 源文件编码，如 "gb18030" / "big5"
+	CoverURL string `json:"coverUrl"`           // /api/book/cover?file=...
+	// 原始文件直通地址 /api/book/original/{hash}，供能原生渲染（如 PDF）的客户端使用
+	OriginalURL string `json:"originalUrl"`
+	// 翻页方向，"rtl" 表示从右到左（日漫），空为默认从左到右
+	Direction string    `json:"direction,omitempty"`
+	Chapters  []Chapter `json:"chapters"`
 	// 内部字段，不序列化
 	CachePath     string `json:"-"` // 磁盘缓存路径 data/cache/{hash}/
+	SourceURL     string `json:"-"` // 源文件 URL（?file= 参数）
+	SourceFile    string `json:"-"` // 下载到本地的原始文件路径
 	CoverFilePath string `json:"-"` // 封面图片在磁盘上的绝对路径
+	// TXT 专用: 检测到固定列宽硬换行时的行宽（显示列数），0 表示未硬换行
+	WrapWidth int `json:"-"`
 }
 
 // Chapter 章节信息
 type Chapter struct {
 	ID    int    `json:"id"`
 	Title string `json:"title"`
+	// 目录层级，0 为顶层（FB2 等嵌套目录的格式使用）
+	Level int `json:"level,omitempty"`
 	// EPUB: 解压后的文件绝对路径
 	// TXT: 源文件路径
 	FilePath string `json:"-"`
@@ -0,0 +1,430 @@
+package parser
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"ebook-reader/internal/job"
+	"ebook-reader/internal/model"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode/v2"
+)
+
+// ArchiveParser 通用压缩包（zip / gz / tar / tgz / 7z / rar）解析器。
+// 包内只有一本书（或通过 Entry 指定了某一本）时按该书自身的格式解析；
+// 只有图片时按漫画处理；有多本书时返回 "collection"，每个条目一章，链接到 ?file=...&entry=...
+type ArchiveParser struct {
+	// Entry 要打开的包内路径，为空时自动选择
+	Entry string
+	// Name 压缩包显示名称，用作合集书名
+	Name string
+	// Inner 返回包内书籍的解析器，为空时使用 GetParser
+	Inner func(name string) (Parser, error)
+
+	inner Parser
+}
+
+func init() {
+	Register(Format{
+		Name:       "archive",
+		Aliases:    []string{"collection"},
+		Extensions: []string{".zip", ".gz", ".tgz", ".tar", ".7z", ".rar"},
+		MIMETypes: []string{"application/zip", "application/gzip", "application/x-gzip", "application/x-tar",
+			"application/x-7z-compressed", "application/vnd.rar", "application/x-rar-compressed"},
+		// 能力取决于包内的书，合集本身只有条目列表
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			switch archiveKind(s.Head) {
+			case "zip":
+				return ".zip", SniffContainer
+			case "rar":
+				return ".rar", SniffContainer
+			case "7z":
+				return ".7z", SniffContainer
+			case "gzip":
+				return ".gz", SniffContainer
+			case "tar":
+				return ".tar", SniffContainer
+			}
+			return "", SniffNone
+		},
+		Fallback: true,
+		New:      func() Parser { return &ArchiveParser{} },
+	})
+}
+
+func (p *ArchiveParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	var entries []string
+	images := 0
+	err := walkArchive(ctx, filePath, func(name string, r io.Reader) error {
+		if skipArchiveEntry(name) {
+			return nil
+		}
+		if comicImageExts[strings.ToLower(path.Ext(name))] {
+			images++
+		} else if isBookEntry(name) {
+			entries = append(entries, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+
+	entry := p.Entry
+	switch {
+	case entry != "":
+		found := false
+		for _, e := range entries {
+			found = found || e == entry
+		}
+		if !found {
+			return nil, fmt.Errorf("archive entry not found: %s", entry)
+		}
+	case len(entries) == 1:
+		entry = entries[0]
+	case len(entries) == 0 && images > 0:
+		p.inner = &ComicParser{}
+		return p.inner.Parse(ctx, filePath, cachePath)
+	case len(entries) == 0:
+		return nil, errors.New("archive: no supported books found")
+	default:
+		return p.collection(entries, cachePath), nil
+	}
+	return p.parseEntry(ctx, filePath, cachePath, entry)
+}
+
+func (p *ArchiveParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	if book.Format != "collection" {
+		inner := p.inner
+		if inner == nil {
+			var err error
+			if inner, err = GetParser(book.Format); err != nil {
+				return "", err
+			}
+		}
+		return inner.ReadChapter(book, chapterID, fileURL)
+	}
+	if chapterID < 0 || chapterID >= len(book.Chapters) {
+		return "", fmt.Errorf("chapter %d out of range", chapterID)
+	}
+	entry := book.Chapters[chapterID].Title
+	href := "/?file=" + url.QueryEscape(book.SourceURL) + "&entry=" + url.QueryEscape(entry)
+	// 章节 iframe 处于沙箱中不能导航顶层窗口，由前端按 data-entry 在阅读器中打开
+	return fmt.Sprintf(`<div class="archive-entry"><h2>%s</h2><p><a href="%s" data-entry="%s">%s</a></p></div>`,
+		html.EscapeString(path.Base(entry)), html.EscapeString(href), html.EscapeString(entry), html.EscapeString(entry)), nil
+}
+
+// collection 多本书的压缩包：每个条目一章，按路径自然排序
+func (p *ArchiveParser) collection(entries []string, cachePath string) *model.Book {
+	sort.SliceStable(entries, func(i, j int) bool { return naturalLess(entries[i], entries[j]) })
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "collection",
+		CachePath: cachePath,
+	}
+	if p.Name != "" {
+		book.Title = p.Name
+	}
+	for i, e := range entries {
+		book.Chapters = append(book.Chapters, model.Chapter{ID: i, Title: e})
+	}
+	return book
+}
+
+// parseEntry 把条目解压到 entries/{hash}/ 下，再用条目自身格式的解析器解析
+func (p *ArchiveParser) parseEntry(ctx context.Context, filePath, cachePath, entry string) (*model.Book, error) {
+	sum := sha256.Sum256([]byte(entry))
+	dir := filepath.Join(cachePath, "entries", hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// 用固定文件名避免包内路径带来的穿越问题，只保留扩展名
+	target := filepath.Join(dir, "raw"+entryExt(entry))
+	job.From(ctx).SetPhase(job.PhaseExtract)
+	err := walkArchive(ctx, filePath, func(name string, r io.Reader) error {
+		if name != entry {
+			return nil
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		budget := extractBudget(maxExtractBytes)
+		err = budget.copy(out, r)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("extract %s: %w", name, err)
+		}
+		return errStopWalk
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+
+	inner := p.Inner
+	if inner == nil {
+		inner = GetParser
+	}
+	ip, err := inner(target)
+	if err != nil {
+		return nil, err
+	}
+	job.From(ctx).SetPhase(job.PhaseParse)
+	book, err := ip.Parse(ctx, target, dir)
+	if err != nil {
+		return nil, err
+	}
+	p.inner = ip
+	book.SourceFile = target
+	// 没有元数据的格式（如 TXT）以条目文件名作书名
+	if book.Title == "Unknown" {
+		base := path.Base(entry)
+		book.Title = strings.TrimSuffix(base, entryExt(base))
+	}
+	return book, nil
+}
+
+// errStopWalk 提前结束压缩包遍历
+var errStopWalk = errors.New("stop walk")
+
+// maxExtractBytes 一次解析从压缩包中解压出的总字节数上限，防止压缩炸弹占满磁盘
+const maxExtractBytes = 2 << 30
+
+// ErrExtractTooLarge 解压出的内容超过 maxExtractBytes，可用 errors.Is 判断
+var ErrExtractTooLarge = errors.New("archive: decompressed size exceeds limit")
+
+// extractBudget 剩余可解压的字节数，同一次解析的各条目共用
+type extractBudget int64
+
+// copy 把 r 写入 w 并扣减额度，超出时返回 ErrExtractTooLarge
+func (b *extractBudget) copy(w io.Writer, r io.Reader) error {
+	n, err := io.Copy(w, io.LimitReader(r, int64(*b)+1))
+	*b -= extractBudget(n)
+	if err != nil {
+		return err
+	}
+	if *b < 0 {
+		return ErrExtractTooLarge
+	}
+	return nil
+}
+
+// skipArchiveEntry 跳过 macOS 资源分支和隐藏文件
+func skipArchiveEntry(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".")
+}
+
+// isBookEntry 条目是否为可单独解析的书籍（嵌套的压缩包不展开）
+func isBookEntry(name string) bool {
+	if path.Ext(name) == "" {
+		return false
+	}
+	p, err := GetParser(name)
+	if err != nil {
+		return false
+	}
+	_, nested := p.(*ArchiveParser)
+	return !nested
+}
+
+// entryExt 返回条目扩展名，保留 .fb2.zip 这样的双扩展名
+func entryExt(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if inner := strings.ToLower(path.Ext(strings.TrimSuffix(name, path.Ext(name)))); inner == ".fb2" {
+		ext = inner + ext
+	}
+	return ext
+}
+
+// walkArchive 按压缩包内顺序遍历普通文件，根据文件头识别 zip / rar / 7z / gzip / tar，
+// 扩展名不可靠（不少 .cbr 实际是 zip）。ctx 取消后读取条目内容即返回错误
+func walkArchive(ctx context.Context, filePath string, fn func(name string, r io.Reader) error) error {
+	visit := fn
+	j := job.From(ctx)
+	fn = func(name string, r io.Reader) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return visit(name, ctxReader{ctx, r, j})
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, 512)
+	n, _ := io.ReadFull(f, magic)
+	f.Close()
+	magic = magic[:n]
+
+	switch archiveKind(magic) {
+	case "zip":
+		zr, err := zip.OpenReader(filePath)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(zf.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "rar":
+		rr, err := rardecode.OpenReader(filePath)
+		if err != nil {
+			return err
+		}
+		defer rr.Close()
+		for {
+			h, err := rr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if h.IsDir {
+				continue
+			}
+			if err := fn(h.Name, rr); err != nil {
+				return err
+			}
+		}
+	case "7z":
+		sr, err := sevenzip.OpenReader(filePath)
+		if err != nil {
+			return err
+		}
+		defer sr.Close()
+		for _, sf := range sr.File {
+			if sf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := sf.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(sf.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "gzip":
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		br := bufio.NewReader(gr)
+		if head, _ := br.Peek(512); isTar(head) {
+			return walkTar(br, fn)
+		}
+		// 单文件 gzip：优先用头部记录的原文件名，否则去掉 .gz 后缀
+		name := path.Base(gr.Name)
+		if gr.Name == "" {
+			name = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+		}
+		return fn(name, br)
+	case "tar":
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return walkTar(f, fn)
+	default:
+		return errors.New("unknown archive type")
+	}
+}
+
+// archiveKind 根据文件头识别压缩包类型："zip" / "rar" / "7z" / "gzip" / "tar"，无法识别时返回空串
+func archiveKind(head []byte) string {
+	switch {
+	case isZip(head):
+		return "zip"
+	case bytes.HasPrefix(head, []byte("Rar!\x1a\x07")):
+		return "rar"
+	case bytes.HasPrefix(head, []byte("7z\xbc\xaf\x27\x1c")):
+		return "7z"
+	case bytes.HasPrefix(head, []byte("\x1f\x8b")):
+		return "gzip"
+	case isTar(head):
+		return "tar"
+	}
+	return ""
+}
+
+// isTar 根据 POSIX / GNU tar 头部 257 偏移处的 "ustar" 标记识别
+func isTar(head []byte) bool {
+	return len(head) >= 262 && string(head[257:262]) == "ustar"
+}
+
+func walkTar(r io.Reader, fn func(name string, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(strings.TrimPrefix(h.Name, "./"), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// ctxReader 每次读取前检查 ctx，用于中断大文件的解压，并向任务上报已解压的字节数
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+	job *job.Job
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.job.AddBytes(int64(n))
+	return n, err
+}
@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"golang.org/x/text/transform"
+)
+
+// charset 编码检测结果
+type charset struct {
+	Name       string            // 规范化编码名，如 "utf-8" / "gb18030" / "big5"
+	Encoding   encoding.Encoding // 解码器，UTF-8 为 nil（无需转换）
+	BOMLen     int               // 文件开头 BOM 的字节数，读取时跳过
+	Confidence float64           // 置信度 0~1
+}
+
+// boms 字节序标记，UTF-32 必须排在 UTF-16 之前（前缀相同）
+var boms = []struct {
+	mark []byte
+	name string
+	enc  encoding.Encoding
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, "utf-8", nil},
+	{[]byte{0xFF, 0xFE, 0x00, 0x00}, "utf-32le", utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM)},
+	{[]byte{0x00, 0x00, 0xFE, 0xFF}, "utf-32be", utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM)},
+	{[]byte{0xFF, 0xFE}, "utf-16le", xunicode.UTF16(xunicode.LittleEndian, xunicode.IgnoreBOM)},
+	{[]byte{0xFE, 0xFF}, "utf-16be", xunicode.UTF16(xunicode.BigEndian, xunicode.IgnoreBOM)},
+}
+
+// legacyCharsets 参与打分的多字节/单字节编码，顺序即同分时的优先级
+var legacyCharsets = []struct {
+	name string
+	enc  encoding.Encoding
+}{
+	{"gb18030", simplifiedchinese.GB18030},
+	{"big5", traditionalchinese.Big5},
+	{"shift_jis", japanese.ShiftJIS},
+	{"euc-jp", japanese.EUCJP},
+	{"euc-kr", korean.EUCKR},
+	{"windows-1252", charmap.Windows1252},
+}
+
+// commonHan 简繁常用汉字，用于区分 GB18030 与 Big5 等解码结果是否“像文字”
+var commonHan = func() map[rune]bool {
+	const chars = "的一是不了人我在有他这中大来上国个到说们为子和你地出道也时年得就那要下以生会自着去之过家学对可她里后小么心多天而能好都然没日于起还发成事只作当想看文无开手十用主行方又如前所本见经头面公同三已老从动两长知民样现分将外但身些与高意进把法此实回二理美点月明其种声全工己话儿者向情部正名定女问力机给等几很业最间新什打便位因重被走电四第门相次东政海口使教西再平真听世气信北少关并内加化由却代军产入先山五太水万市眼体别处总才场师书比住员九笑性通目华报立马命张活难神数件安表原车白应路期叫死常提感金何更反合放做系计或司利受光王果亲界及今京务制解各任至清物台象记边共风战干接它许八特觉望直服毛林题建南度统色字请交爱让认算论百吃义科怎元社术结六功指思非流每青管夫连远资队跟带花快条院变联言权往展该领传近留红治决周保达办运武半候七必城父强步完革深区即求品士转量空甚众技轻程告江语英基派满式李息写呢识极令黄德收脸钱党倒未持取设始版双历越史商千片容研像找友孩站广改议形委早房音火际则首单据导影失拿网香似斯专石若兵弟谁校读志飞观争究包组造落视济喜离虽坏兴切希备母乎土否" +
+		"這個來們為說時會過學對裡後麼頭見經動兩長樣現將與進實點種聲發開話兒從問機給幾業間報馬張難數車應還當電東門無國氣聽記邊風戰幹許題統請愛讓認論義結聯權領傳遠紅決達辦運條區覺變軍產場師書處總員華眼體別錢黨歷雙寫識極話聲讀誰視濟喜離雖壞興備網專飛觀爭組黃臉倒單據導親務計許該種貓雲聖語錯隊連帶並內萬體亂鳥龍魚門"
+	m := make(map[rune]bool, len(chars))
+	for _, r := range chars {
+		m[r] = true
+	}
+	return m
+}()
+
+// detectCharset 根据文件开头的采样检测编码：BOM 优先，其次 UTF-8 校验，
+// 最后对各候选编码的解码结果打分取最高者
+func detectCharset(sample []byte) charset {
+	for _, b := range boms {
+		if bytes.HasPrefix(sample, b.mark) {
+			return charset{Name: b.name, Encoding: b.enc, BOMLen: len(b.mark), Confidence: 1}
+		}
+	}
+
+	// 正常文本不含 NUL，出现 NUL 基本可以断定是无 BOM 的 UTF-16
+	if cs, ok := detectUTF16(sample); ok {
+		return cs
+	}
+
+	// 采样可能在多字节字符中间截断，去掉末尾不完整的字符再校验
+	if utf8.Valid(trimPartialRune(sample)) {
+		return charset{Name: "utf-8", Confidence: 1}
+	}
+
+	best := charset{Name: "gb18030", Encoding: simplifiedchinese.GB18030}
+	bestScore := -1e9
+	for _, c := range legacyCharsets {
+		score := scoreDecoding(c.enc, sample)
+		if score > bestScore {
+			bestScore = score
+			best = charset{Name: c.name, Encoding: c.enc, Confidence: max(0, score)}
+		}
+	}
+	return best
+}
+
+// detectUTF16 通过 NUL 字节在奇偶位置上的分布判断无 BOM 的 UTF-16 字节序
+func detectUTF16(sample []byte) (charset, bool) {
+	var even, odd int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	pairs := len(sample) / 2
+	if pairs == 0 || even+odd < pairs/10 {
+		return charset{}, false
+	}
+	conf := float64(max(even, odd)-min(even, odd)) / float64(even+odd)
+	if odd > even {
+		return charset{Name: "utf-16le", Encoding: xunicode.UTF16(xunicode.LittleEndian, xunicode.IgnoreBOM), Confidence: conf}, true
+	}
+	return charset{Name: "utf-16be", Encoding: xunicode.UTF16(xunicode.BigEndian, xunicode.IgnoreBOM), Confidence: conf}, true
+}
+
+// scoreDecoding 用指定编码解码采样，按非 ASCII 字符的“可信度”求平均分：
+// 常用汉字、假名、谚文等得高分，替换字符、控制字符、私用区字符扣分
+func scoreDecoding(enc encoding.Encoding, sample []byte) float64 {
+	decoded, _, _ := transform.Bytes(enc.NewDecoder(), sample)
+	// 采样末尾可能截断了一个多字节字符，丢掉最后一个字符避免误扣分
+	if _, size := utf8.DecodeLastRune(decoded); size > 0 {
+		decoded = decoded[:len(decoded)-size]
+	}
+
+	var total float64
+	var n int
+	for _, r := range string(decoded) {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		n++
+		switch {
+		case r == utf8.RuneError:
+			total -= 3
+		case commonHan[r]:
+			total += 1
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			total += 1
+		case r >= 0xAC00 && r <= 0xD7A3:
+			// 谚文音节（不含很少单独出现的字母）
+			total += 1
+		case r >= 0x3000 && r <= 0x303F, r >= 0xFF00 && r <= 0xFFEF:
+			// CJK 标点、全角字符
+			total += 0.8
+		case unicode.Is(unicode.Han, r):
+			total += 0.3
+		case r >= 0xC0 && r <= 0xFF && unicode.IsLetter(r):
+			// Latin-1 带音调字母
+			total += 0.6
+		case r >= 0xE000 && r <= 0xF8FF, unicode.IsControl(r), !unicode.IsGraphic(r):
+			total -= 1
+		case r < 0x100:
+			total += 0.2
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n)
+}
+
+// lookupCharset 按名称查找编码（用于 &encoding= 强制指定），支持 WHATWG 定义的各种别名
+func lookupCharset(name string) (charset, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, b := range boms {
+		if b.name == name && b.enc != nil {
+			return charset{Name: b.name, Encoding: b.enc, Confidence: 1}, nil
+		}
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return charset{}, fmt.Errorf("unsupported encoding: %s", name)
+	}
+	canonical, err := htmlindex.Name(enc)
+	if err != nil {
+		return charset{}, fmt.Errorf("unsupported encoding: %s", name)
+	}
+	if canonical == "utf-8" {
+		enc = nil
+	}
+	return charset{Name: canonical, Encoding: enc, Confidence: 1}, nil
+}
+
+// NormalizeEncoding 校验并规范化编码名，如 "GBK" -> "gbk"、"sjis" -> "shift_jis"
+func NormalizeEncoding(name string) (string, error) {
+	cs, err := lookupCharset(name)
+	if err != nil {
+		return "", err
+	}
+	return cs.Name, nil
+}
+
+// trimPartialRune 去掉字节切片末尾不完整的 UTF-8 字符
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
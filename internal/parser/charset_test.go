@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	xunicode "golang.org/x/text/encoding/unicode"
+)
+
+const (
+	sampleSimplified  = "第一章 风起\n他说这个国家的人们都在学习新的东西，我们也要努力工作，为了美好的明天。\n"
+	sampleTraditional = "第一章 風起\n他說這個國家的人們都在學習新的東西，我們也要努力工作，為了美好的明天。\n"
+)
+
+func encode(t *testing.T, enc encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return b
+}
+
+func TestDetectCharset(t *testing.T) {
+	utf16le := xunicode.UTF16(xunicode.LittleEndian, xunicode.IgnoreBOM)
+	utf16be := xunicode.UTF16(xunicode.BigEndian, xunicode.IgnoreBOM)
+	tests := []struct {
+		name    string
+		sample  []byte
+		want    string
+		wantBOM int
+	}{
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, sampleSimplified...), "utf-8", 3},
+		{"utf-16le bom", append([]byte{0xFF, 0xFE}, encode(t, utf16le, sampleSimplified)...), "utf-16le", 2},
+		{"utf-16be bom", append([]byte{0xFE, 0xFF}, encode(t, utf16be, sampleSimplified)...), "utf-16be", 2},
+		{"utf-32le bom", []byte{0xFF, 0xFE, 0x00, 0x00, 'a', 0, 0, 0}, "utf-32le", 4},
+		{"utf-16le without bom", encode(t, utf16le, "Chapter 1\nHello world, "+sampleSimplified), "utf-16le", 0},
+		{"utf-16be without bom", encode(t, utf16be, "Chapter 1\nHello world, "+sampleSimplified), "utf-16be", 0},
+		{"utf-8", []byte(sampleSimplified), "utf-8", 0},
+		{"utf-8 truncated mid rune", []byte(sampleSimplified)[:len(sampleSimplified)-2], "utf-8", 0},
+		{"ascii", []byte("Chapter 1\nHello world.\n"), "utf-8", 0},
+		{"gbk", encode(t, simplifiedchinese.GBK, strings.Repeat(sampleSimplified, 3)), "gb18030", 0},
+		{"big5", encode(t, traditionalchinese.Big5, strings.Repeat(sampleTraditional, 3)), "big5", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := detectCharset(tt.sample)
+			if cs.Name != tt.want || cs.BOMLen != tt.wantBOM {
+				t.Errorf("detectCharset = %s (bom %d), want %s (bom %d)", cs.Name, cs.BOMLen, tt.want, tt.wantBOM)
+			}
+		})
+	}
+}
+
+// 开头超过一个采样长度的内容全是 ASCII 时，按之后的非 ASCII 内容检测编码
+func TestTXTCharsetAfterASCIIHead(t *testing.T) {
+	head := strings.Repeat("This is an English license header line.\n", txtReadBufSize/40+100)
+	tests := []struct {
+		name     string
+		enc      encoding.Encoding
+		text     string
+		want     string
+		wantText string // 解码后的正文应包含的内容
+	}{
+		{"gbk", simplifiedchinese.GBK, sampleSimplified, "gb18030", "美好的明天"},
+		{"big5", traditionalchinese.Big5, sampleTraditional, "big5", "為了美好的明天"},
+		{"utf-8", encoding.Nop, sampleSimplified, "utf-8", "美好的明天"},
+		{"ascii only", encoding.Nop, "The end.\n", "utf-8", "The end."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "book.txt")
+			data := append([]byte(head), encode(t, tt.enc, strings.Repeat(tt.text, 20))...)
+			if err := os.WriteFile(src, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			book, err := (&TXTParser{}).Parse(context.Background(), src, dir)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if book.Encoding != tt.want {
+				t.Fatalf("Encoding = %s, want %s", book.Encoding, tt.want)
+			}
+			last, err := (&TXTParser{}).ReadChapter(book, len(book.Chapters)-1, "")
+			if err != nil {
+				t.Fatalf("ReadChapter: %v", err)
+			}
+			if !strings.Contains(last, tt.wantText) {
+				t.Errorf("last chapter does not contain %q after decoding", tt.wantText)
+			}
+		})
+	}
+}
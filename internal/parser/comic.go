@@ -0,0 +1,220 @@
+package parser
+
+import (
+	"context"
+	"ebook-reader/internal/job"
+	"ebook-reader/internal/model"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ComicParser 漫画压缩包解析器，支持 CBZ (zip) / CBR (rar) / CB7 (7z)，每张图片为一页（一章）
+type ComicParser struct{}
+
+func init() {
+	Register(Format{
+		Name:         "comic",
+		Extensions:   []string{".cbz", ".cbr", ".cb7"},
+		MIMETypes:    []string{"application/vnd.comicbook+zip", "application/vnd.comicbook-rar", "application/x-cbz", "application/x-cbr", "application/x-cb7"},
+		Capabilities: Capabilities{Resources: true, Cover: true},
+		// 只能识别出容器，由文件名区分是否为漫画
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			switch archiveKind(s.Head) {
+			case "zip":
+				return ".cbz", SniffContainer
+			case "rar":
+				return ".cbr", SniffContainer
+			case "7z":
+				return ".cb7", SniffContainer
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &ComicParser{} },
+	})
+}
+
+// 漫画页及文档内嵌图片支持的图片扩展名
+var comicImageExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
+	".webp": true, ".bmp": true, ".avif": true,
+}
+
+// comicInfo ComicInfo.xml 中用到的字段
+type comicInfo struct {
+	Title     string `xml:"Title"`
+	Series    string `xml:"Series"`
+	Number    string `xml:"Number"`
+	Writer    string `xml:"Writer"`
+	Penciller string `xml:"Penciller"`
+	// Manga 为 "YesAndRightToLeft" 时按从右到左阅读
+	Manga string `xml:"Manga"`
+	Pages []struct {
+		Image    int    `xml:"Image,attr"`
+		Type     string `xml:"Type,attr"`
+		Bookmark string `xml:"Bookmark,attr"`
+	} `xml:"Pages>Page"`
+}
+
+// comicPage 解压后的一页
+type comicPage struct {
+	name string // 压缩包内的原始路径，用于排序
+	file string // 缓存目录中的文件名
+}
+
+func (p *ComicParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	pageDir := filepath.Join(cachePath, "pages")
+	if err := os.MkdirAll(pageDir, 0755); err != nil {
+		return nil, err
+	}
+
+	var pages []comicPage
+	var info *comicInfo
+	budget := extractBudget(maxExtractBytes)
+	job.From(ctx).SetPhase(job.PhaseExtract)
+	err := walkArchive(ctx, filePath, func(name string, r io.Reader) error {
+		base := path.Base(name)
+		if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			return nil
+		}
+		if strings.EqualFold(base, "ComicInfo.xml") {
+			var ci comicInfo
+			if err := xml.NewDecoder(r).Decode(&ci); err == nil {
+				info = &ci
+			}
+			return nil
+		}
+		ext := strings.ToLower(path.Ext(base))
+		if !comicImageExts[ext] {
+			return nil
+		}
+		// 按解压顺序编号存储，避免包内路径带来的穿越和重名问题
+		file := fmt.Sprintf("%05d%s", len(pages)+1, ext)
+		out, err := os.Create(filepath.Join(pageDir, file))
+		if err != nil {
+			return err
+		}
+		err = budget.copy(out, r)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("extract %s: %w", name, err)
+		}
+		pages = append(pages, comicPage{name: name, file: file})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open comic: %w", err)
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("comic: no images found")
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return naturalLess(pages[i].name, pages[j].name) })
+
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "comic",
+		CachePath: cachePath,
+	}
+	bookmarks := make(map[int]string)
+	cover := 0
+	if info != nil {
+		switch {
+		case info.Title != "":
+			book.Title = info.Title
+		case info.Series != "" && info.Number != "":
+			book.Title = info.Series + " #" + info.Number
+		case info.Series != "":
+			book.Title = info.Series
+		}
+		if info.Writer != "" {
+			book.Author = info.Writer
+		} else if info.Penciller != "" {
+			book.Author = info.Penciller
+		}
+		if strings.EqualFold(info.Manga, "YesAndRightToLeft") {
+			book.Direction = "rtl"
+		}
+		for _, pg := range info.Pages {
+			if pg.Bookmark != "" {
+				bookmarks[pg.Image] = pg.Bookmark
+			}
+			if pg.Type == "FrontCover" && pg.Image >= 0 && pg.Image < len(pages) {
+				cover = pg.Image
+			}
+		}
+	}
+	book.CoverFilePath = filepath.Join(pageDir, pages[cover].file)
+
+	for i, pg := range pages {
+		title := bookmarks[i]
+		if title == "" {
+			title = fmt.Sprintf("Page %d", i+1)
+		}
+		book.Chapters = append(book.Chapters, model.Chapter{
+			ID:       i,
+			Title:    title,
+			FilePath: filepath.Join(pageDir, pg.file),
+		})
+	}
+	return book, nil
+}
+
+func (p *ComicParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	if chapterID < 0 || chapterID >= len(book.Chapters) {
+		return "", fmt.Errorf("chapter %d out of range", chapterID)
+	}
+	ch := book.Chapters[chapterID]
+	content := fmt.Sprintf(`<div class="comic-page"><img src="%s" alt="%s"></div>`,
+		filepath.Base(ch.FilePath), html.EscapeString(ch.Title))
+	return rewriteResourceLinks(content, filepath.Dir(ch.FilePath), book.CachePath, fileURL), nil
+}
+
+// naturalLess 自然排序：忽略大小写，数字段按数值比较（page2 < page10）
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		da, db := isDigit(a[0]), isDigit(b[0])
+		if da && db {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+			// 去掉前导零后先比长度再比字典序，避免大数溢出
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
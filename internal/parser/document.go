@@ -0,0 +1,223 @@
+package parser
+
+import (
+	"archive/zip"
+	"ebook-reader/internal/model"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// docSplitLevel 文档类格式（DOCX / ODT 等）中 1..docSplitLevel 级标题开始新章节，更深的标题只渲染
+const docSplitLevel = 3
+
+// docWriter 按标题切分文档并把章节写入缓存目录
+type docWriter struct {
+	book  *model.Book
+	dir   string
+	class string // 章节外层 div 的 class
+	split int    // 1..split 级标题开始新章节
+	buf   strings.Builder
+	title string
+	level int
+}
+
+func newDocWriter(book *model.Book, class string) (*docWriter, error) {
+	w := &docWriter{
+		book:  book,
+		dir:   filepath.Join(book.CachePath, "chapters"),
+		class: class,
+		split: docSplitLevel,
+		title: book.Title,
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// heading 写入 level 级标题（1 起），浅层标题先结束上一章
+func (w *docWriter) heading(level int, title string, inner string) error {
+	if err := w.section(level, title); err != nil {
+		return err
+	}
+	level = min(max(level, 1), 6)
+	fmt.Fprintf(&w.buf, "<h%d>%s</h%d>", level, inner, level)
+	return nil
+}
+
+// section 遇到 level 级标题时按需结束上一章，标题 HTML 由调用方自行写入
+func (w *docWriter) section(level int, title string) error {
+	if level > w.split {
+		return nil
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.title, w.level = title, level-1
+	return nil
+}
+
+// write 追加一段 HTML
+func (w *docWriter) write(html string) {
+	w.buf.WriteString(html)
+}
+
+// flush 把缓冲区写成一章，空章节（如第一个标题之前没有内容）跳过
+func (w *docWriter) flush() error {
+	if strings.TrimSpace(w.buf.String()) == "" {
+		w.buf.Reset()
+		return nil
+	}
+	id := len(w.book.Chapters)
+	title := w.title
+	if title == "" {
+		title = fmt.Sprintf("Chapter %d", id+1)
+	}
+	content := `<div class="` + w.class + `">` + w.buf.String() + `</div>`
+	path := filepath.Join(w.dir, fmt.Sprintf("%05d.html", id))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("write chapter: %w", err)
+	}
+	w.book.Chapters = append(w.book.Chapters, model.Chapter{
+		ID:       id,
+		Title:    title,
+		Level:    w.level,
+		FilePath: path,
+	})
+	w.buf.Reset()
+	return nil
+}
+
+// zipDoc 以 zip 为容器的文档（DOCX / ODT / HTMLZ），按需读取条目并导出图片
+type zipDoc struct {
+	zr       *zip.ReadCloser
+	files    map[string]*zip.File
+	imageDir string
+	images   map[string]string // 包内路径 -> 导出后的文件名
+	budget   extractBudget     // 导出图片共用的解压额度
+}
+
+func openZipDoc(filePath string, cachePath string) (*zipDoc, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	z := &zipDoc{
+		zr:       zr,
+		files:    make(map[string]*zip.File, len(zr.File)),
+		imageDir: filepath.Join(cachePath, "images"),
+		images:   make(map[string]string),
+		budget:   maxExtractBytes,
+	}
+	for _, f := range zr.File {
+		z.files[f.Name] = f
+	}
+	return z, nil
+}
+
+func (z *zipDoc) Close() error {
+	return z.zr.Close()
+}
+
+// tree 读取并解析包内的 XML 文件，文件不存在时返回 nil
+func (z *zipDoc) tree(name string) (*xmlNode, error) {
+	f, ok := z.files[name]
+	if !ok {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	d := xml.NewDecoder(rc)
+	d.Strict = false
+	root, err := parseXMLTree(d, nil)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", name, err)
+	}
+	return root, nil
+}
+
+// image 导出包内图片，返回相对于章节文件的路径；图片不存在或不是常见的图片格式时返回空串
+func (z *zipDoc) image(name string) string {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if saved, ok := z.images[name]; ok {
+		return saved
+	}
+	f, ok := z.files[name]
+	ext := strings.ToLower(path.Ext(name))
+	if !ok || !comicImageExts[ext] {
+		return ""
+	}
+	// 包内路径只用于查找，导出文件名按序号生成，避免路径穿越和重名
+	file := fmt.Sprintf("%04d%s", len(z.images)+1, ext)
+	if err := extractZipFile(f, filepath.Join(z.imageDir, file), &z.budget); err != nil {
+		return ""
+	}
+	z.images[name] = "../images/" + file
+	return z.images[name]
+}
+
+// coreProps 读取 Dublin Core 元数据中的标题和作者（DOCX core.xml / ODT meta.xml / OPF）
+func coreProps(root *xmlNode) (title, author string) {
+	if root == nil {
+		return "", ""
+	}
+	if n := root.find("title"); n != nil {
+		title = n.textContent()
+	}
+	for _, name := range []string{"creator", "initial-creator"} {
+		if n := root.find(name); n != nil && n.textContent() != "" {
+			author = n.textContent()
+			break
+		}
+	}
+	return title, author
+}
+
+// isOff 判断 OOXML 开关属性是否为关闭（<w:b w:val="0"/>）
+func isOff(n *xmlNode) bool {
+	switch strings.ToLower(n.attrs["val"]) {
+	case "0", "false", "off", "none":
+		return true
+	}
+	return false
+}
+
+// linkHref 文档超链接的目标：只保留 http / https / mailto 链接和 # 锚点，
+// 其他协议（如 javascript:）及无法解析的地址返回空字符串
+func linkHref(target string) string {
+	target = strings.TrimSpace(target)
+	if strings.HasPrefix(target, "#") {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return target
+	}
+	return ""
+}
+
+// wrapFormat 按粗体/斜体包裹行内 HTML
+func wrapFormat(html string, bold, italic bool) string {
+	if html == "" {
+		return ""
+	}
+	if italic {
+		html = "<em>" + html + "</em>"
+	}
+	if bold {
+		html = "<strong>" + html + "</strong>"
+	}
+	return html
+}
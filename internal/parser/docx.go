@@ -0,0 +1,470 @@
+package parser
+
+import (
+	"context"
+	"ebook-reader/internal/model"
+	"errors"
+	"fmt"
+	"html"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DOCXParser Word 文档 (WordprocessingML) 解析器，按标题样式切分章节
+type DOCXParser struct{}
+
+func init() {
+	Register(Format{
+		Name:         "docx",
+		Extensions:   []string{".docx"},
+		MIMETypes:    []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		Capabilities: Capabilities{Resources: true, Cover: true, TOCTree: true, SearchText: true},
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if s.ZipFiles["word/document.xml"] {
+				return "", SniffExact
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &DOCXParser{} },
+	})
+}
+
+// docxStyle styles.xml 中的段落样式
+type docxStyle struct {
+	level   int // 标题级别，0 表示正文
+	basedOn string
+}
+
+// docxRel document.xml.rels 中的关系
+type docxRel struct {
+	target   string
+	external bool
+}
+
+// docxConverter 把 document.xml 的节点树渲染为 HTML
+type docxConverter struct {
+	doc    *zipDoc
+	w      *docWriter
+	styles map[string]docxStyle
+	numFmt map[string]map[string]string // numId -> ilvl -> numFmt
+	rels   map[string]docxRel
+	lists  []string // 当前打开的列表标签栈（ul / ol）
+}
+
+func (p *DOCXParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	doc, err := openZipDoc(filePath, cachePath)
+	if err != nil {
+		return nil, fmt.Errorf("open docx: %w", err)
+	}
+	defer doc.Close()
+
+	root, err := doc.tree("word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+	var body *xmlNode
+	if root != nil {
+		body = root.find("body")
+	}
+	if body == nil {
+		return nil, errors.New("docx: missing word/document.xml body")
+	}
+
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "docx",
+		CachePath: cachePath,
+	}
+	core, err := doc.tree("docProps/core.xml")
+	if err != nil {
+		return nil, err
+	}
+	if title, author := coreProps(core); title != "" || author != "" {
+		if title != "" {
+			book.Title = title
+		}
+		if author != "" {
+			book.Author = author
+		}
+	}
+	if thumb := doc.image("docProps/thumbnail.jpeg"); thumb != "" {
+		book.CoverFilePath = filepath.Join(doc.imageDir, path.Base(thumb))
+	}
+
+	c := &docxConverter{
+		doc:    doc,
+		styles: make(map[string]docxStyle),
+		numFmt: make(map[string]map[string]string),
+		rels:   make(map[string]docxRel),
+	}
+	if err := c.loadStyles(); err != nil {
+		return nil, err
+	}
+	if err := c.loadNumbering(); err != nil {
+		return nil, err
+	}
+	if err := c.loadRels(); err != nil {
+		return nil, err
+	}
+	if c.w, err = newDocWriter(book, "doc-chapter"); err != nil {
+		return nil, err
+	}
+	if err := c.blocks(body); err != nil {
+		return nil, err
+	}
+	c.closeLists(0)
+	if err := c.w.flush(); err != nil {
+		return nil, err
+	}
+	if len(book.Chapters) == 0 {
+		return nil, errors.New("docx: document is empty")
+	}
+	return book, nil
+}
+
+func (p *DOCXParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	return readChapterFile(book, chapterID, fileURL)
+}
+
+// loadStyles 读取段落样式，样式名 "heading N" / "Title" 或 outlineLvl 视为标题
+func (c *docxConverter) loadStyles() error {
+	root, err := c.doc.tree("word/styles.xml")
+	if err != nil || root == nil {
+		return err
+	}
+	styles := root.find("styles")
+	if styles == nil {
+		return nil
+	}
+	for _, s := range styles.children {
+		if s.name != "style" || s.attrs["type"] != "paragraph" {
+			continue
+		}
+		var st docxStyle
+		if n := s.child("basedOn"); n != nil {
+			st.basedOn = n.attrs["val"]
+		}
+		name := ""
+		if n := s.child("name"); n != nil {
+			name = strings.ToLower(n.attrs["val"])
+		}
+		switch {
+		case name == "title":
+			st.level = 1
+		case strings.HasPrefix(name, "heading "):
+			if lv, err := strconv.Atoi(strings.TrimPrefix(name, "heading ")); err == nil && lv > 0 {
+				st.level = lv
+			}
+		}
+		if ppr := s.child("pPr"); ppr != nil && st.level == 0 {
+			st.level = outlineLevel(ppr)
+		}
+		c.styles[s.attrs["styleId"]] = st
+	}
+	return nil
+}
+
+// loadNumbering 读取列表编号格式，用于区分有序 / 无序列表
+func (c *docxConverter) loadNumbering() error {
+	root, err := c.doc.tree("word/numbering.xml")
+	if err != nil || root == nil {
+		return err
+	}
+	numbering := root.find("numbering")
+	if numbering == nil {
+		return nil
+	}
+	abstract := make(map[string]map[string]string)
+	for _, a := range numbering.children {
+		if a.name != "abstractNum" {
+			continue
+		}
+		levels := make(map[string]string)
+		for _, lvl := range a.children {
+			if lvl.name != "lvl" {
+				continue
+			}
+			if f := lvl.child("numFmt"); f != nil {
+				levels[lvl.attrs["ilvl"]] = f.attrs["val"]
+			}
+		}
+		abstract[a.attrs["abstractNumId"]] = levels
+	}
+	for _, n := range numbering.children {
+		if n.name != "num" {
+			continue
+		}
+		if a := n.child("abstractNumId"); a != nil {
+			c.numFmt[n.attrs["numId"]] = abstract[a.attrs["val"]]
+		}
+	}
+	return nil
+}
+
+func (c *docxConverter) loadRels() error {
+	root, err := c.doc.tree("word/_rels/document.xml.rels")
+	if err != nil || root == nil {
+		return err
+	}
+	rels := root.find("Relationships")
+	if rels == nil {
+		return nil
+	}
+	for _, r := range rels.children {
+		if r.name == "Relationship" {
+			c.rels[r.attrs["Id"]] = docxRel{
+				target:   r.attrs["Target"],
+				external: r.attrs["TargetMode"] == "External",
+			}
+		}
+	}
+	return nil
+}
+
+// outlineLevel 返回 pPr 中 outlineLvl 对应的标题级别（1 起），正文返回 0
+func outlineLevel(ppr *xmlNode) int {
+	if n := ppr.child("outlineLvl"); n != nil {
+		// outlineLvl 9 表示正文
+		if lv, err := strconv.Atoi(n.attrs["val"]); err == nil && lv >= 0 && lv < 9 {
+			return lv + 1
+		}
+	}
+	return 0
+}
+
+// headingLevel 沿 basedOn 链查找样式的标题级别
+func (c *docxConverter) headingLevel(styleID string) int {
+	for i := 0; styleID != "" && i < 10; i++ {
+		st, ok := c.styles[styleID]
+		if !ok {
+			return 0
+		}
+		if st.level > 0 {
+			return st.level
+		}
+		styleID = st.basedOn
+	}
+	return 0
+}
+
+// blocks 渲染 body / 内容控件中的块级元素
+func (c *docxConverter) blocks(n *xmlNode) error {
+	for _, b := range n.children {
+		switch b.name {
+		case "p":
+			if err := c.paragraph(b); err != nil {
+				return err
+			}
+		case "tbl":
+			c.closeLists(0)
+			c.w.write(c.table(b))
+		case "sdt":
+			if content := b.child("sdtContent"); content != nil {
+				if err := c.blocks(content); err != nil {
+					return err
+				}
+			}
+		case "customXml", "ins":
+			if err := c.blocks(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *docxConverter) paragraph(p *xmlNode) error {
+	level, numID, ilvl := 0, "", -1
+	if ppr := p.child("pPr"); ppr != nil {
+		if s := ppr.child("pStyle"); s != nil {
+			level = c.headingLevel(s.attrs["val"])
+		}
+		if lv := outlineLevel(ppr); lv > 0 {
+			level = lv
+		}
+		if num := ppr.child("numPr"); num != nil {
+			if n := num.child("numId"); n != nil && n.attrs["val"] != "0" {
+				numID, ilvl = n.attrs["val"], 0
+				if l := num.child("ilvl"); l != nil {
+					ilvl, _ = strconv.Atoi(l.attrs["val"])
+				}
+			}
+		}
+	}
+	inner := strings.TrimSpace(c.inline(p))
+
+	if level > 0 {
+		c.closeLists(0)
+		if inner == "" {
+			return nil
+		}
+		return c.w.heading(level, p.textContent(), inner)
+	}
+	if numID != "" {
+		tag := "ul"
+		if f := c.numFmt[numID][strconv.Itoa(ilvl)]; f != "" && f != "bullet" && f != "none" {
+			tag = "ol"
+		}
+		// 列表项的 <li> 保持打开，更深层的列表嵌套在其中
+		depth := min(max(ilvl, 0), 8) + 1
+		c.closeLists(depth)
+		if len(c.lists) == depth {
+			c.w.write("</li>")
+		}
+		for len(c.lists) < depth {
+			c.lists = append(c.lists, tag)
+			c.w.write("<" + tag + ">")
+		}
+		c.w.write("<li>" + inner)
+		return nil
+	}
+	c.closeLists(0)
+	if inner != "" {
+		c.w.write("<p>" + inner + "</p>")
+	}
+	return nil
+}
+
+// closeLists 关闭列表直到剩余 depth 层
+func (c *docxConverter) closeLists(depth int) {
+	for len(c.lists) > depth {
+		c.w.write("</li></" + c.lists[len(c.lists)-1] + ">")
+		c.lists = c.lists[:len(c.lists)-1]
+	}
+}
+
+// inline 渲染段落内的 run、超链接和图片
+func (c *docxConverter) inline(n *xmlNode) string {
+	var buf strings.Builder
+	for _, r := range n.children {
+		switch r.name {
+		case "r":
+			buf.WriteString(c.run(r))
+		case "hyperlink":
+			inner := c.inline(r)
+			href := ""
+			if rel, ok := c.rels[r.attrs["id"]]; ok && rel.external {
+				href = linkHref(rel.target)
+			}
+			if href != "" && inner != "" {
+				buf.WriteString(`<a href="` + html.EscapeString(href) + `">` + inner + `</a>`)
+			} else {
+				buf.WriteString(inner)
+			}
+		case "ins", "smartTag", "fldSimple", "customXml":
+			buf.WriteString(c.inline(r))
+		case "sdt":
+			if content := r.child("sdtContent"); content != nil {
+				buf.WriteString(c.inline(content))
+			}
+		}
+	}
+	return buf.String()
+}
+
+func (c *docxConverter) run(r *xmlNode) string {
+	bold, italic := false, false
+	if rpr := r.child("rPr"); rpr != nil {
+		if b := rpr.child("b"); b != nil {
+			bold = !isOff(b)
+		}
+		if i := rpr.child("i"); i != nil {
+			italic = !isOff(i)
+		}
+	}
+	var buf strings.Builder
+	for _, e := range r.children {
+		switch e.name {
+		case "t":
+			for _, t := range e.children {
+				buf.WriteString(htmlEscape(t.text))
+			}
+		case "tab":
+			buf.WriteString(" ")
+		case "br", "cr":
+			// 分页符不渲染
+			if e.attrs["type"] != "page" {
+				buf.WriteString("<br>")
+			}
+		case "noBreakHyphen":
+			buf.WriteString("-")
+		case "drawing":
+			buf.WriteString(c.drawing(e))
+		case "pict", "object":
+			if img := e.find("imagedata"); img != nil {
+				buf.WriteString(c.imageTag(img.attrs["id"], img.attrs["title"]))
+			}
+		case "AlternateContent":
+			// 只取 Choice，Fallback 是同一内容的旧版表示
+			if choice := e.child("Choice"); choice != nil {
+				buf.WriteString(c.run(choice))
+			}
+		}
+	}
+	return wrapFormat(buf.String(), bold, italic)
+}
+
+func (c *docxConverter) drawing(d *xmlNode) string {
+	blip := d.find("blip")
+	if blip == nil {
+		return ""
+	}
+	alt := ""
+	if pr := d.find("docPr"); pr != nil {
+		alt = pr.attrs["descr"]
+	}
+	return c.imageTag(blip.attrs["embed"], alt)
+}
+
+// imageTag 按关系 ID 导出图片并生成 <img>
+func (c *docxConverter) imageTag(relID string, alt string) string {
+	rel, ok := c.rels[relID]
+	if !ok || rel.external {
+		return ""
+	}
+	src := c.doc.image(path.Join("word", rel.target))
+	if src == "" {
+		return ""
+	}
+	return fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(src), html.EscapeString(alt))
+}
+
+func (c *docxConverter) table(t *xmlNode) string {
+	var buf strings.Builder
+	buf.WriteString(`<table class="doc-table">`)
+	for _, tr := range t.children {
+		if tr.name != "tr" {
+			continue
+		}
+		buf.WriteString("<tr>")
+		for _, tc := range tr.children {
+			if tc.name != "tc" {
+				continue
+			}
+			span := ""
+			if pr := tc.child("tcPr"); pr != nil {
+				if g := pr.child("gridSpan"); g != nil && g.attrs["val"] != "1" {
+					span = fmt.Sprintf(` colspan="%s"`, html.EscapeString(g.attrs["val"]))
+				}
+			}
+			var cell []string
+			for _, b := range tc.children {
+				switch b.name {
+				case "p":
+					if s := strings.TrimSpace(c.inline(b)); s != "" {
+						cell = append(cell, s)
+					}
+				case "tbl":
+					cell = append(cell, c.table(b))
+				}
+			}
+			buf.WriteString("<td" + span + ">" + strings.Join(cell, "<br>") + "</td>")
+		}
+		buf.WriteString("</tr>")
+	}
+	buf.WriteString("</table>")
+	return buf.String()
+}
@@ -2,19 +2,37 @@ package parser
 
 import (
 	"archive/zip"
+	"context"
+	"ebook-reader/internal/job"
 	"ebook-reader/internal/model"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 )
 
 // EPUBParser EPUB 格式解析器
 type EPUBParser struct{}
 
+func init() {
+	Register(Format{
+		Name:         "epub",
+		Extensions:   []string{".epub"},
+		MIMETypes:    []string{"application/epub+zip"},
+		Capabilities: Capabilities{Resources: true, Cover: true, SearchText: true},
+		// 缺少 mimetype 条目的 EPUB 也有 META-INF/container.xml
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if s.Mimetype == "application/epub+zip" || s.ZipFiles["META-INF/container.xml"] {
+				return "", SniffExact
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &EPUBParser{} },
+	})
+}
+
 // opfPackage OPF 包文档结构
 type opfPackage struct {
 	XMLName  xml.Name    `xml:"package"`
@@ -57,12 +75,9 @@ type rootFileItem struct {
 	FullPath string `xml:"full-path,attr"`
 }
 
-// 匹配 src="..." href="..." xlink:href="..." 中的相对路径资源引用
-var resourceAttrRe = regexp.MustCompile(`(?i)(src|href|xlink:href)\s*=\s*"([^"]*)"`)
-
-func (p *EPUBParser) Parse(filePath string, cachePath string) (*model.Book, error) {
+func (p *EPUBParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
 	// 解压 EPUB 到 cachePath
-	if err := unzipEPUB(filePath, cachePath); err != nil {
+	if err := unzipEPUB(ctx, filePath, cachePath); err != nil {
 		return nil, fmt.Errorf("unzip epub: %w", err)
 	}
 
@@ -155,50 +170,12 @@ func (p *EPUBParser) ReadChapter(book *model.Book, chapterID int, fileURL string
 		return "", fmt.Errorf("read chapter file: %w", err)
 	}
 
-	content := string(data)
-
 	// 改写章节内容中的资源路径为 API 代理地址
-	// 章节文件所在目录（相对于 cachePath 解析相对路径）
-	chapterDir := filepath.Dir(ch.FilePath)
-
-	content = resourceAttrRe.ReplaceAllStringFunc(content, func(match string) string {
-		subs := resourceAttrRe.FindStringSubmatch(match)
-		if len(subs) < 3 {
-			return match
-		}
-		attr := subs[1]
-		val := subs[2]
-
-		// 跳过: data URI, 绝对 URL, 锚点链接, 空值
-		if val == "" || strings.HasPrefix(val, "data:") || strings.HasPrefix(val, "http://") || strings.HasPrefix(val, "https://") || strings.HasPrefix(val, "#") {
-			return match
-		}
-
-		// 对于 href 属性，只改写指向资源文件的（图片/CSS/字体），跳过 .xhtml/.html 章节链接
-		if strings.EqualFold(attr, "href") {
-			ext := strings.ToLower(filepath.Ext(val))
-			if ext == ".xhtml" || ext == ".html" || ext == ".htm" || ext == "" {
-				return match
-			}
-		}
-
-		// 解析相对路径为相对于 cachePath 的路径
-		absPath := filepath.Join(chapterDir, val)
-		relPath, err := filepath.Rel(book.CachePath, absPath)
-		if err != nil {
-			return match
-		}
-		// 统一用正斜杠
-		relPath = filepath.ToSlash(relPath)
-
-		return fmt.Sprintf(`%s="/api/book/resource/%s/%s"`, attr, fileURL, relPath)
-	})
-
-	return content, nil
+	return rewriteResourceLinks(string(data), filepath.Dir(ch.FilePath), book.CachePath, fileURL), nil
 }
 
 // unzipEPUB 解压 EPUB 文件到目标目录
-func unzipEPUB(src string, dest string) error {
+func unzipEPUB(ctx context.Context, src string, dest string) error {
 	r, err := zip.OpenReader(src)
 	if err != nil {
 		return err
@@ -233,7 +210,7 @@ func unzipEPUB(src string, dest string) error {
 			return err
 		}
 
-		_, err = io.Copy(outFile, rc)
+		_, err = io.Copy(outFile, ctxReader{ctx, rc, job.From(ctx)})
 		rc.Close()
 		outFile.Close()
 		if err != nil {
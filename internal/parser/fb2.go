@@ -0,0 +1,527 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"ebook-reader/internal/model"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// FB2Parser FictionBook 2 格式解析器，支持 .fb2 与 .fb2.zip
+type FB2Parser struct{}
+
+func init() {
+	Register(Format{
+		Name:         "fb2",
+		Extensions:   []string{".fb2", ".fb2.zip"},
+		MIMETypes:    []string{"application/x-fictionbook+xml", "application/x-fictionbook"},
+		Capabilities: Capabilities{Resources: true, Cover: true, TOCTree: true, SearchText: true},
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if m := s.Markup(); bytes.HasPrefix(m, []byte("<")) && bytes.Contains(m, []byte("<fictionbook")) {
+				return ".fb2", SniffExact
+			}
+			// 只含一个 .fb2 文件的 zip
+			if len(s.ZipFiles) == 1 {
+				for name := range s.ZipFiles {
+					if strings.EqualFold(filepath.Ext(name), ".fb2") {
+						return ".fb2.zip", SniffExact
+					}
+				}
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &FB2Parser{} },
+	})
+}
+
+func (p *FB2Parser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	src, err := openFB2(filePath, cachePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	imageDir := filepath.Join(cachePath, "images")
+	root, images, err := parseFB2Tree(src, imageDir)
+	if err != nil {
+		return nil, fmt.Errorf("parse fb2: %w", err)
+	}
+
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "fb2",
+		CachePath: cachePath,
+	}
+
+	// description/title-info 元数据
+	var titleInfo *xmlNode
+	if desc := root.child("description"); desc != nil {
+		titleInfo = desc.child("title-info")
+	}
+	if titleInfo != nil {
+		if t := titleInfo.child("book-title"); t != nil && t.textContent() != "" {
+			book.Title = t.textContent()
+		}
+		var authors []string
+		for _, c := range titleInfo.children {
+			if c.name == "author" {
+				if name := fb2AuthorName(c); name != "" {
+					authors = append(authors, name)
+				}
+			}
+		}
+		if len(authors) > 0 {
+			book.Author = strings.Join(authors, ", ")
+		}
+		if cover := titleInfo.child("coverpage"); cover != nil {
+			if img := cover.child("image"); img != nil {
+				if name, ok := images[strings.TrimPrefix(img.attrs["href"], "#")]; ok {
+					book.CoverFilePath = filepath.Join(imageDir, name)
+				}
+			}
+		}
+	}
+
+	// 第一个 body 为正文，name="notes"/"comments" 的 body 为脚注
+	var mainBody *xmlNode
+	notes := make(map[string]*xmlNode)
+	for _, c := range root.children {
+		if c.name != "body" {
+			continue
+		}
+		if name := c.attrs["name"]; name == "notes" || name == "comments" || (mainBody != nil && name != "") {
+			collectFB2Notes(c, notes)
+			continue
+		}
+		if mainBody == nil {
+			mainBody = c
+		}
+	}
+	if mainBody == nil {
+		return nil, fmt.Errorf("fb2: no body")
+	}
+
+	w := &fb2Writer{
+		dir:    filepath.Join(cachePath, "chapters"),
+		images: images,
+		notes:  notes,
+		book:   book,
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := w.walk(mainBody, 0, book.Title); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+func (p *FB2Parser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	return readChapterFile(book, chapterID, fileURL)
+}
+
+// openFB2 打开 FB2 文件；.fb2.zip 先把其中的 .fb2 解压到缓存目录
+func openFB2(filePath string, cachePath string) (io.ReadCloser, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("open fb2: %w", err)
+	}
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	if !bytes.Equal(magic[:n], []byte("PK\x03\x04")) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	f.Close()
+
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("open fb2.zip: %w", err)
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if !strings.EqualFold(filepath.Ext(zf.Name), ".fb2") {
+			continue
+		}
+		target := filepath.Join(cachePath, "book.fb2")
+		budget := extractBudget(maxExtractBytes)
+		if err := extractZipFile(zf, target, &budget); err != nil {
+			return nil, fmt.Errorf("extract fb2: %w", err)
+		}
+		return os.Open(target)
+	}
+	return nil, fmt.Errorf("no .fb2 file in archive")
+}
+
+// extractZipFile 将 zip 中的单个文件解压到 target，解压的字节数计入 budget
+func extractZipFile(zf *zip.File, target string, budget *extractBudget) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if err := budget.copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// parseFB2Tree 读取 FB2 XML 为节点树，<binary> 直接解码写入 imageDir，
+// 返回 binary id -> 文件名 的映射
+func parseFB2Tree(r io.Reader, imageDir string) (*xmlNode, map[string]string, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	// FB2 常见 windows-1251 / koi8-r 等编码
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	images := make(map[string]string)
+	root, err := parseXMLTree(d, func(start xml.StartElement) (bool, error) {
+		if start.Name.Local != "binary" {
+			return false, nil
+		}
+		return true, saveFB2Binary(d, start, imageDir, images)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 根节点下应只有 FictionBook 一个元素
+	if fb := root.child("FictionBook"); fb != nil {
+		return fb, images, nil
+	}
+	return root, images, nil
+}
+
+// saveFB2Binary 解码 <binary> 中的 base64 内容并写入文件
+func saveFB2Binary(d *xml.Decoder, start xml.StartElement, imageDir string, images map[string]string) error {
+	var id, contentType string
+	for _, a := range start.Attr {
+		switch a.Name.Local {
+		case "id":
+			id = a.Value
+		case "content-type":
+			contentType = a.Value
+		}
+	}
+	var data bytes.Buffer
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			data.Write(cd)
+		}
+		if _, ok := tok.(xml.EndElement); ok {
+			break
+		}
+	}
+	if id == "" {
+		return nil
+	}
+	if _, ok := images[id]; ok {
+		// 重复的 id 以第一个为准
+		return nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data.String()), ""))
+	if err != nil {
+		// 损坏的图片不影响正文
+		return nil
+	}
+	if err := os.MkdirAll(imageDir, 0755); err != nil {
+		return err
+	}
+	// id 只用于查找，文件名按序号生成，避免 "." / ".." 之类的 id 及重名
+	name := fmt.Sprintf("%04d%s", len(images)+1, fb2ImageExt(id, contentType))
+	if err := os.WriteFile(filepath.Join(imageDir, name), raw, 0644); err != nil {
+		return fmt.Errorf("write binary: %w", err)
+	}
+	images[id] = name
+	return nil
+}
+
+// fb2ImageExt 图片文件的扩展名：优先取 id 中的扩展名，否则按 content-type，都不是常见图片格式时为空
+func fb2ImageExt(id, contentType string) string {
+	if ext := strings.ToLower(path.Ext(id)); comicImageExts[ext] {
+		return ext
+	}
+	exts, _ := mime.ExtensionsByType(contentType)
+	for _, ext := range exts {
+		if comicImageExts[ext] {
+			return ext
+		}
+	}
+	return ""
+}
+
+// fb2AuthorName 拼接作者姓名
+func fb2AuthorName(n *xmlNode) string {
+	var parts []string
+	for _, field := range []string{"first-name", "middle-name", "last-name"} {
+		if c := n.child(field); c != nil && c.textContent() != "" {
+			parts = append(parts, c.textContent())
+		}
+	}
+	if len(parts) == 0 {
+		if c := n.child("nickname"); c != nil {
+			return c.textContent()
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// collectFB2Notes 收集脚注 body 中带 id 的 section
+func collectFB2Notes(n *xmlNode, notes map[string]*xmlNode) {
+	for _, c := range n.children {
+		if c.name != "section" {
+			continue
+		}
+		if id := c.attrs["id"]; id != "" {
+			notes[id] = c
+		}
+		collectFB2Notes(c, notes)
+	}
+}
+
+// fb2Writer 将正文 section 渲染为章节 HTML 文件
+type fb2Writer struct {
+	dir    string
+	images map[string]string
+	notes  map[string]*xmlNode
+	book   *model.Book
+
+	// 当前章节
+	buf      bytes.Buffer
+	noteRefs []string
+	level    int
+	title    string
+}
+
+// walk 深度优先遍历 section：section 的标题和第一个子 section 之前的内容构成一章，
+// 子 section 各自成章，level 记录嵌套层级用于目录树
+func (w *fb2Writer) walk(n *xmlNode, level int, fallbackTitle string) error {
+	title := fallbackTitle
+	if t := n.child("title"); t != nil && t.textContent() != "" {
+		title = t.textContent()
+	}
+	w.start(level, title)
+	for _, c := range n.children {
+		if c.name == "section" {
+			if err := w.flush(); err != nil {
+				return err
+			}
+			if err := w.walk(c, level+1, ""); err != nil {
+				return err
+			}
+			// 子 section 之后的剩余内容沿用父标题
+			w.start(level, title)
+			continue
+		}
+		w.render(c, level)
+	}
+	return w.flush()
+}
+
+// start 开始一个新章节
+func (w *fb2Writer) start(level int, title string) {
+	w.buf.Reset()
+	w.noteRefs = nil
+	w.level = level
+	w.title = title
+}
+
+// flush 将当前章节写入文件，没有正文的章节跳过
+func (w *fb2Writer) flush() error {
+	if strings.TrimSpace(w.buf.String()) == "" {
+		return nil
+	}
+	id := len(w.book.Chapters)
+	title := w.title
+	if title == "" {
+		title = fmt.Sprintf("Chapter %d", id+1)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(`<div class="fb2-chapter">`)
+	out.Write(w.buf.Bytes())
+	w.writeNotes(&out)
+	out.WriteString(`</div>`)
+
+	path := filepath.Join(w.dir, fmt.Sprintf("%05d.html", id))
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write chapter: %w", err)
+	}
+	w.book.Chapters = append(w.book.Chapters, model.Chapter{
+		ID:       id,
+		Title:    title,
+		FilePath: path,
+		Level:    w.level,
+	})
+	w.buf.Reset()
+	return nil
+}
+
+// writeNotes 在章节末尾附上本章引用的脚注
+func (w *fb2Writer) writeNotes(out *bytes.Buffer) {
+	if len(w.noteRefs) == 0 {
+		return
+	}
+	out.WriteString(`<aside class="fb2-notes"><hr>`)
+	seen := make(map[string]bool)
+	for _, id := range w.noteRefs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		note := w.notes[id]
+		fmt.Fprintf(out, `<div class="fb2-note" id="%s">`, html.EscapeString(id))
+		var body fb2Writer
+		body.images = w.images
+		for _, c := range note.children {
+			if c.name == "title" {
+				fmt.Fprintf(out, `<p class="fb2-note-label"><a href="#ref-%s">%s</a></p>`, html.EscapeString(id), html.EscapeString(c.textContent()))
+				continue
+			}
+			body.render(c, 0)
+		}
+		out.Write(body.buf.Bytes())
+		out.WriteString(`</div>`)
+	}
+	out.WriteString(`</aside>`)
+}
+
+// fb2Tags FB2 元素到 HTML 的简单映射
+var fb2Tags = map[string]string{
+	"p":             "p",
+	"emphasis":      "em",
+	"strong":        "strong",
+	"strikethrough": "s",
+	"sub":           "sub",
+	"sup":           "sup",
+	"code":          "code",
+	"cite":          "blockquote",
+	"table":         "table",
+	"tr":            "tr",
+	"td":            "td",
+	"th":            "th",
+}
+
+// fb2Classes 渲染为带 class 的 div/p 的元素
+var fb2Classes = map[string]string{
+	"epigraph":    "div",
+	"poem":        "div",
+	"stanza":      "div",
+	"annotation":  "div",
+	"v":           "p",
+	"subtitle":    "p",
+	"text-author": "p",
+}
+
+// render 将 FB2 节点渲染为 HTML 写入当前章节
+func (w *fb2Writer) render(n *xmlNode, level int) {
+	if n.name == "" {
+		w.buf.WriteString(html.EscapeString(n.text))
+		return
+	}
+	switch n.name {
+	case "title":
+		h := min(level+1, 6)
+		fmt.Fprintf(&w.buf, `<h%d>`, h)
+		first := true
+		for _, c := range n.children {
+			if c.name == "" && strings.TrimSpace(c.text) == "" {
+				continue
+			}
+			if !first {
+				w.buf.WriteString("<br>")
+			}
+			first = false
+			w.renderChildren(c, level)
+		}
+		fmt.Fprintf(&w.buf, `</h%d>`, h)
+	case "empty-line":
+		w.buf.WriteString(`<br>`)
+	case "image":
+		id := strings.TrimPrefix(n.attrs["href"], "#")
+		if name, ok := w.images[id]; ok {
+			fmt.Fprintf(&w.buf, `<img src="../images/%s" alt="%s">`, name, html.EscapeString(n.attrs["alt"]))
+		}
+	case "a":
+		href := n.attrs["href"]
+		if strings.HasPrefix(href, "#") {
+			id := href[1:]
+			if _, ok := w.notes[id]; ok {
+				w.noteRefs = append(w.noteRefs, id)
+				fmt.Fprintf(&w.buf, `<a class="fb2-noteref" id="ref-%s" href="#%s"><sup>`, html.EscapeString(id), html.EscapeString(id))
+				w.renderChildren(n, level)
+				w.buf.WriteString(`</sup></a>`)
+				return
+			}
+		}
+		href = linkHref(href)
+		if href == "" {
+			w.renderChildren(n, level)
+			return
+		}
+		fmt.Fprintf(&w.buf, `<a href="%s">`, html.EscapeString(href))
+		w.renderChildren(n, level)
+		w.buf.WriteString(`</a>`)
+	default:
+		if tag, ok := fb2Tags[n.name]; ok {
+			fmt.Fprintf(&w.buf, `<%s>`, tag)
+			w.renderChildren(n, level)
+			fmt.Fprintf(&w.buf, `</%s>`, tag)
+			return
+		}
+		if tag, ok := fb2Classes[n.name]; ok {
+			fmt.Fprintf(&w.buf, `<%s class="fb2-%s">`, tag, n.name)
+			w.renderChildren(n, level)
+			fmt.Fprintf(&w.buf, `</%s>`, tag)
+			return
+		}
+		// 未知元素只保留内容
+		w.renderChildren(n, level)
+	}
+}
+
+func (w *fb2Writer) renderChildren(n *xmlNode, level int) {
+	if n.name == "" {
+		w.render(n, level)
+		return
+	}
+	for _, c := range n.children {
+		w.render(c, level)
+	}
+}
@@ -0,0 +1,610 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"ebook-reader/internal/model"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	htmlcharset "golang.org/x/net/html/charset"
+)
+
+// HTMLParser 单文件 HTML 与 Calibre HTMLZ（zip: HTML + 图片 + metadata.opf）解析器，
+// 按 h1/h2 标题切分章节，没有标题时按带 id 的 section / article 切分
+type HTMLParser struct {
+	// SourceURL 源文件地址，.html 中的相对图片据此下载，由服务端注入
+	SourceURL string
+	// Fetch 下载远程图片，为空时相对图片保持原样
+	Fetch FetchFunc
+}
+
+func init() {
+	Register(Format{
+		Name:         "html",
+		Extensions:   []string{".html", ".htm", ".xhtml", ".htmlz"},
+		MIMETypes:    []string{"text/html", "application/xhtml+xml"},
+		Capabilities: Capabilities{Resources: true, Cover: true, TOCTree: true, SearchText: true},
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if s.ZipFiles["index.html"] && s.ZipFiles["metadata.opf"] {
+				return ".htmlz", SniffExact
+			}
+			m := s.Markup()
+			if bytes.HasPrefix(m, []byte("<!doctype html")) || bytes.HasPrefix(m, []byte("<html")) ||
+				bytes.HasPrefix(m, []byte("<?xml")) && bytes.Contains(m, []byte("<html")) {
+				return ".html", SniffExact
+			}
+			// 不以标签开头的 HTML 片段只能靠文件名识别
+			return sniffText(s)
+		},
+		New: func() Parser { return &HTMLParser{} },
+	})
+}
+
+// htmlSection 切分出的一章
+type htmlSection struct {
+	title string
+	level int
+	nodes []*html.Node
+}
+
+// 不输出到章节中的元素
+var htmlDropTags = map[atom.Atom]bool{
+	atom.Script: true, atom.Noscript: true, atom.Style: true, atom.Link: true, atom.Meta: true,
+	atom.Iframe: true, atom.Object: true, atom.Embed: true, atom.Form: true,
+}
+
+// 包含章节边界时拆开处理的容器元素，其余元素整体归入当前章节
+var htmlContainerTags = map[atom.Atom]bool{
+	atom.Body: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Header: true, atom.Footer: true, atom.Center: true,
+}
+
+func (p *HTMLParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "html",
+		CachePath: cachePath,
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	f.Close()
+
+	var doc *html.Node
+	var image func(ref string) string
+	var base *url.URL
+	if bytes.Equal(magic[:n], []byte("PK\x03\x04")) {
+		z, err := openZipDoc(filePath, cachePath)
+		if err != nil {
+			return nil, fmt.Errorf("open htmlz: %w", err)
+		}
+		defer z.Close()
+		book.Format = "htmlz"
+		if doc, image, err = p.openHTMLZ(z, book); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = parseHTMLBytes(data); err != nil {
+			return nil, fmt.Errorf("parse html: %w", err)
+		}
+		if u, err := url.Parse(p.SourceURL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			base = u
+		}
+		image = newRemoteImages(ctx, p.SourceURL, p.Fetch, filepath.Join(cachePath, "images")).save
+	}
+
+	title, author := htmlHeadMeta(doc)
+	if book.Title == "Unknown" && title != "" {
+		book.Title = title
+	}
+	if book.Author == "Unknown" && author != "" {
+		book.Author = author
+	}
+	body := htmlFind(doc, atom.Body)
+	if body == nil {
+		return nil, errors.New("html: missing body")
+	}
+	cleanHTML(body)
+
+	sections := splitHTML(body)
+	if len(sections) == 0 {
+		return nil, errors.New("html: document is empty")
+	}
+	if book.Title == "Unknown" {
+		if h := htmlFind(body, atom.H1); h != nil && htmlText(h) != "" {
+			book.Title = htmlText(h)
+		}
+	}
+
+	// 章内锚点所在章节，用于跨章跳转
+	ids := make(map[string]int)
+	for i, sec := range sections {
+		for _, n := range sec.nodes {
+			htmlWalk(n, func(e *html.Node) {
+				for _, a := range e.Attr {
+					if a.Key == "id" || a.Key == "name" && e.DataAtom == atom.A {
+						if _, ok := ids[a.Val]; !ok {
+							ids[a.Val] = i
+						}
+					}
+				}
+			})
+		}
+	}
+
+	w, err := newDocWriter(book, "html-chapter")
+	if err != nil {
+		return nil, err
+	}
+	for i, sec := range sections {
+		var buf bytes.Buffer
+		for _, n := range sec.nodes {
+			htmlWalk(n, func(e *html.Node) { rewriteHTMLRefs(e, ids, base, image) })
+			if err := html.Render(&buf, n); err != nil {
+				return nil, fmt.Errorf("render html: %w", err)
+			}
+		}
+		title := sec.title
+		if i == 0 && title == "" {
+			title = book.Title
+		}
+		if err := w.section(1, title); err != nil {
+			return nil, err
+		}
+		w.level = sec.level
+		w.write(buf.String())
+	}
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+func (p *HTMLParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	return readChapterFile(book, chapterID, fileURL)
+}
+
+// openHTMLZ 读取 HTMLZ 中的主 HTML 与 OPF 元数据，返回文档和包内图片导出函数
+func (p *HTMLParser) openHTMLZ(z *zipDoc, book *model.Book) (*html.Node, func(string) string, error) {
+	var index, opf string
+	var names []string
+	for name := range z.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ext := strings.ToLower(path.Ext(name))
+		switch {
+		case opf == "" && ext == ".opf":
+			opf = name
+		case strings.EqualFold(name, "index.html"):
+			index = name
+		case index == "" && (ext == ".html" || ext == ".htm" || ext == ".xhtml"):
+			index = name
+		}
+	}
+	if index == "" {
+		return nil, nil, errors.New("htmlz: no html file in archive")
+	}
+
+	rc, err := z.files[index].Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := parseHTMLBytes(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse html: %w", err)
+	}
+
+	dir := path.Dir(index)
+	image := func(ref string) string {
+		u, err := url.Parse(ref)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+			return ""
+		}
+		return z.image(path.Join(dir, u.Path))
+	}
+
+	if opf != "" {
+		root, err := z.tree(opf)
+		if err != nil {
+			return nil, nil, err
+		}
+		title, author := coreProps(root)
+		if title != "" {
+			book.Title = title
+		}
+		if author != "" {
+			book.Author = author
+		}
+		if cover := opfCoverHref(root); cover != "" {
+			if src := z.image(path.Join(path.Dir(opf), cover)); src != "" {
+				book.CoverFilePath = filepath.Join(z.imageDir, path.Base(src))
+			}
+		}
+	}
+	return doc, image, nil
+}
+
+// opfCoverHref 从 OPF 的 <meta name="cover"> 或 guide 中找出封面图片路径
+func opfCoverHref(root *xmlNode) string {
+	if root == nil {
+		return ""
+	}
+	coverID := ""
+	var manifest []*xmlNode
+	var walk func(*xmlNode)
+	var guide string
+	walk = func(n *xmlNode) {
+		for _, c := range n.children {
+			switch {
+			case c.name == "meta" && c.attrs["name"] == "cover":
+				coverID = c.attrs["content"]
+			case c.name == "item":
+				manifest = append(manifest, c)
+			case c.name == "reference" && c.attrs["type"] == "cover" && guide == "":
+				guide = c.attrs["href"]
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+	for _, item := range manifest {
+		if coverID != "" && item.attrs["id"] == coverID {
+			return item.attrs["href"]
+		}
+	}
+	if comicImageExts[strings.ToLower(path.Ext(guide))] {
+		return guide
+	}
+	return ""
+}
+
+// parseHTMLBytes 按 BOM / <meta charset> 识别编码后解析 HTML
+func parseHTMLBytes(data []byte) (*html.Node, error) {
+	r, err := htmlcharset.NewReader(bytes.NewReader(data), "")
+	if err != nil {
+		return nil, err
+	}
+	return html.Parse(r)
+}
+
+// htmlHeadMeta 读取 <title> 与 <meta name="author"> / DC.creator
+func htmlHeadMeta(doc *html.Node) (title, author string) {
+	head := htmlFind(doc, atom.Head)
+	if head == nil {
+		return "", ""
+	}
+	if t := htmlFind(head, atom.Title); t != nil {
+		title = htmlText(t)
+	}
+	htmlWalk(head, func(n *html.Node) {
+		if n.DataAtom != atom.Meta || author != "" {
+			return
+		}
+		switch strings.ToLower(htmlAttr(n, "name")) {
+		case "author", "dc.creator", "dcterms.creator":
+			author = strings.TrimSpace(htmlAttr(n, "content"))
+		}
+	})
+	return title, author
+}
+
+// cleanHTML 删除脚本、样式、嵌入对象、事件属性及不安全协议（如 javascript:）的地址属性
+func cleanHTML(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode, c.Type == html.ElementNode && htmlDropTags[c.DataAtom]:
+			n.RemoveChild(c)
+		case c.Type == html.ElementNode:
+			attrs := c.Attr[:0]
+			for _, a := range c.Attr {
+				if strings.HasPrefix(strings.ToLower(a.Key), "on") {
+					continue
+				}
+				if htmlURLAttrs[a.Key] && !safeURL(a.Key, a.Val) {
+					continue
+				}
+				attrs = append(attrs, a)
+			}
+			c.Attr = attrs
+			cleanHTML(c)
+		}
+		c = next
+	}
+}
+
+// htmlURLAttrs 值为地址的属性，按 safeURL 过滤协议
+var htmlURLAttrs = map[string]bool{"href": true, "src": true, "action": true, "formaction": true, "poster": true}
+
+// safeURL 地址属性是否可以保留：相对地址及 http / https / mailto 协议，src 另允许 data:（内嵌图片）。
+// 与浏览器一样先去掉其中的制表符、换行符及开头的空白和控制字符再取协议，避免 " java\tscript:" 之类的写法绕过
+func safeURL(key, val string) bool {
+	v := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, val)
+	v = strings.TrimLeftFunc(v, func(r rune) bool { return r <= ' ' })
+	i := strings.IndexAny(v, ":/?#")
+	if i <= 0 || v[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(v[:i]) {
+	case "http", "https", "mailto":
+		return true
+	case "data":
+		return key == "src"
+	}
+	return false
+}
+
+// splitHTML 把 body 切分为章节：有 h1/h2 时以标题为界，否则以带 id 的 section / article 为界
+func splitHTML(body *html.Node) []*htmlSection {
+	hasH1, hasH2, hasSection := false, false, false
+	htmlWalk(body, func(n *html.Node) {
+		switch n.DataAtom {
+		case atom.H1:
+			hasH1 = true
+		case atom.H2:
+			hasH2 = true
+		case atom.Section, atom.Article:
+			if htmlAttr(n, "id") != "" {
+				hasSection = true
+			}
+		}
+	})
+
+	var isBoundary func(n *html.Node) bool
+	var sectionOf func(n *html.Node) *htmlSection
+	switch {
+	case hasH1 || hasH2:
+		isBoundary = func(n *html.Node) bool {
+			return n.DataAtom == atom.H1 || n.DataAtom == atom.H2
+		}
+		sectionOf = func(n *html.Node) *htmlSection {
+			level := 0
+			// 没有 h1 时 h2 即为顶层
+			if n.DataAtom == atom.H2 && hasH1 {
+				level = 1
+			}
+			return &htmlSection{title: htmlText(n), level: level}
+		}
+	case hasSection:
+		isBoundary = func(n *html.Node) bool {
+			return (n.DataAtom == atom.Section || n.DataAtom == atom.Article) && htmlAttr(n, "id") != ""
+		}
+		sectionOf = func(n *html.Node) *htmlSection {
+			title := ""
+			htmlWalk(n, func(e *html.Node) {
+				if title == "" && isHeading(e) {
+					title = htmlText(e)
+				}
+			})
+			if title == "" {
+				title = htmlAttr(n, "title")
+			}
+			if title == "" {
+				title = htmlAttr(n, "id")
+			}
+			return &htmlSection{title: title}
+		}
+	default:
+		isBoundary = func(*html.Node) bool { return false }
+	}
+
+	containsBoundary := func(n *html.Node) bool {
+		found := false
+		htmlWalk(n, func(e *html.Node) {
+			if e != n && isBoundary(e) {
+				found = true
+			}
+		})
+		return found
+	}
+
+	cur := &htmlSection{}
+	sections := []*htmlSection{cur}
+	var walk func(parent *html.Node)
+	walk = func(parent *html.Node) {
+		for c := parent.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.ElementNode && isBoundary(c):
+				next := sectionOf(c)
+				// 标题前紧挨着的空锚点（<a id="chap1"></a>）归入新章节
+				i := len(cur.nodes)
+				for i > 0 && isEmptyAnchor(cur.nodes[i-1]) {
+					i--
+				}
+				next.nodes = append(append(next.nodes, cur.nodes[i:]...), c)
+				cur.nodes = cur.nodes[:i]
+				cur = next
+				sections = append(sections, cur)
+			case c.Type == html.ElementNode && htmlContainerTags[c.DataAtom] && containsBoundary(c):
+				walk(c)
+			default:
+				cur.nodes = append(cur.nodes, c)
+			}
+		}
+	}
+	walk(body)
+
+	var result []*htmlSection
+	for _, sec := range sections {
+		for _, n := range sec.nodes {
+			if htmlHasContent(n) {
+				result = append(result, sec)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// rewriteHTMLRefs 处理单个元素的引用：章内锚点补充 data-chapter，图片导出到缓存，
+// 其他相对链接按源地址解析为绝对地址
+func rewriteHTMLRefs(n *html.Node, ids map[string]int, base *url.URL, image func(string) string) {
+	if n.Type != html.ElementNode {
+		return
+	}
+	switch n.DataAtom {
+	case atom.A:
+		href := htmlAttr(n, "href")
+		if strings.HasPrefix(href, "#") {
+			if k, ok := ids[href[1:]]; ok {
+				n.Attr = append(n.Attr, html.Attribute{Key: "data-chapter", Val: strconv.Itoa(k)})
+			}
+			return
+		}
+		if base == nil || href == "" {
+			return
+		}
+		if u, err := url.Parse(href); err == nil && u.Scheme == "" {
+			setHTMLAttr(n, "href", base.ResolveReference(u).String())
+		}
+	case atom.Img:
+		src := htmlAttr(n, "src")
+		if src == "" {
+			return
+		}
+		if saved := image(src); saved != "" {
+			setHTMLAttr(n, "src", saved)
+		} else if u, err := url.Parse(src); err == nil && u.Scheme == "" && base != nil {
+			setHTMLAttr(n, "src", base.ResolveReference(u).String())
+		}
+		// srcset 中的相对地址无法统一处理，直接去掉
+		removeHTMLAttr(n, "srcset")
+	}
+}
+
+func htmlWalk(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		htmlWalk(c, fn)
+	}
+}
+
+// htmlFind 深度优先返回第一个指定标签的元素
+func htmlFind(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if f := htmlFind(c, a); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// htmlText 返回元素下所有文本，按空白归一化
+func htmlText(n *html.Node) string {
+	var buf strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+			return
+		}
+		if n.DataAtom == atom.Br {
+			buf.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// htmlHasContent 判断节点是否有可见内容（文本或图片）
+func htmlHasContent(n *html.Node) bool {
+	switch {
+	case n.Type == html.TextNode:
+		return strings.TrimSpace(n.Data) != ""
+	case n.Type != html.ElementNode:
+		return false
+	case n.DataAtom == atom.Img || n.DataAtom == atom.Svg || n.DataAtom == atom.Hr:
+		return true
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if htmlHasContent(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyAnchor 空白文本或不含内容的 <a id> / <a name> 锚点
+func isEmptyAnchor(n *html.Node) bool {
+	if n.Type == html.TextNode {
+		return strings.TrimSpace(n.Data) == ""
+	}
+	return n.Type == html.ElementNode && n.DataAtom == atom.A && htmlAttr(n, "href") == "" && !htmlHasContent(n)
+}
+
+func isHeading(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return true
+	}
+	return false
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setHTMLAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeHTMLAttr(n *html.Node, key string) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Key != key {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
+}
@@ -0,0 +1,272 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"ebook-reader/internal/model"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+// MarkdownParser Markdown 解析器（CommonMark + GFM 表格），按标题切分章节
+type MarkdownParser struct {
+	// SplitLevel 1..SplitLevel 级标题开始新章节，0 使用默认值 2
+	SplitLevel int
+	// SourceURL 源文件地址，用于解析相对图片路径，由服务端注入
+	SourceURL string
+	// Fetch 下载远程图片，为空时相对图片保持原样
+	Fetch FetchFunc
+}
+
+func init() {
+	Register(Format{
+		Name:         "md",
+		Extensions:   []string{".md", ".markdown"},
+		MIMETypes:    []string{"text/markdown", "text/x-markdown"},
+		Capabilities: Capabilities{Resources: true, TOCTree: true, SearchText: true},
+		Sniff:        sniffText,
+		New:          func() Parser { return &MarkdownParser{} },
+	})
+}
+
+// FetchFunc 下载书中引用的远程资源，返回内容
+type FetchFunc func(ctx context.Context, url string) ([]byte, error)
+
+// defaultMarkdownSplit Markdown 默认按一、二级标题切分章节
+const defaultMarkdownSplit = 2
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.Table))
+
+func (p *MarkdownParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	meta, source := splitFrontMatter(data)
+
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "md",
+		CachePath: cachePath,
+	}
+	if v := meta["title"]; v != "" {
+		book.Title = v
+	}
+	if v := meta["author"]; v != "" {
+		book.Author = v
+	}
+
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	// 没有 front matter 标题时取第一个一级标题
+	if meta["title"] == "" {
+		for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+			if h, ok := n.(*ast.Heading); ok && h.Level == 1 {
+				if t := mdText(h, source); t != "" {
+					book.Title = t
+				}
+				break
+			}
+		}
+	}
+
+	images := newRemoteImages(ctx, p.SourceURL, p.Fetch, filepath.Join(cachePath, "images"))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if img, ok := n.(*ast.Image); ok && entering {
+			if src := images.save(string(img.Destination)); src != "" {
+				img.Destination = []byte(src)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	w, err := newDocWriter(book, "md-chapter")
+	if err != nil {
+		return nil, err
+	}
+	w.split = p.SplitLevel
+	if w.split <= 0 {
+		w.split = defaultMarkdownSplit
+	}
+	renderer := markdown.Renderer()
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if h, ok := n.(*ast.Heading); ok {
+			if err := w.section(h.Level, mdText(h, source)); err != nil {
+				return nil, err
+			}
+		}
+		var buf bytes.Buffer
+		if err := renderer.Render(&buf, source, n); err != nil {
+			return nil, fmt.Errorf("render markdown: %w", err)
+		}
+		w.write(buf.String())
+	}
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	if len(book.Chapters) == 0 {
+		return nil, errors.New("markdown: document is empty")
+	}
+	return book, nil
+}
+
+func (p *MarkdownParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	return readChapterFile(book, chapterID, fileURL)
+}
+
+// mdText 返回节点下的纯文本
+func mdText(n ast.Node, source []byte) string {
+	var buf strings.Builder
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch t := c.(type) {
+		case *ast.Text:
+			buf.Write(t.Segment.Value(source))
+			if t.SoftLineBreak() || t.HardLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(t.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(buf.String())
+}
+
+// splitFrontMatter 拆出开头的 YAML front matter（--- 包围），只识别简单的 key: value
+// 与列表形式的 author / authors，返回小写键名的元数据和剩余正文
+func splitFrontMatter(data []byte) (map[string]string, []byte) {
+	meta := make(map[string]string)
+	if !bytes.HasPrefix(data, []byte("---\n")) && !bytes.HasPrefix(data, []byte("---\r\n")) {
+		return meta, data
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if l := strings.TrimRight(lines[i], "\r\n"); l == "---" || l == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return meta, data
+	}
+
+	var authors []string
+	key := ""
+	for _, l := range lines[1:end] {
+		l = strings.TrimRight(l, "\r\n")
+		trimmed := strings.TrimSpace(l)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// 缩进的 "- item" 属于上一个键的列表
+		if strings.HasPrefix(trimmed, "- ") && key != "" {
+			if key == "author" || key == "authors" {
+				authors = append(authors, yamlScalar(trimmed[2:]))
+			}
+			continue
+		}
+		k, v, ok := strings.Cut(l, ":")
+		if !ok || strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t") {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+		case (key == "author" || key == "authors") && strings.HasPrefix(v, "["):
+			for _, a := range strings.Split(strings.Trim(v, "[]"), ",") {
+				authors = append(authors, yamlScalar(a))
+			}
+		case key == "author" || key == "authors":
+			authors = append(authors, yamlScalar(v))
+		default:
+			meta[key] = yamlScalar(v)
+		}
+	}
+	var names []string
+	for _, a := range authors {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	meta["author"] = strings.Join(names, ", ")
+	return meta, []byte(strings.Join(lines[end+1:], ""))
+}
+
+// yamlScalar 去掉 YAML 标量两端的空白和引号
+func yamlScalar(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		s = s[1 : len(s)-1]
+	}
+	return s
+}
+
+// remoteImages 把文档中的相对图片按源文件地址解析后下载到缓存目录
+type remoteImages struct {
+	ctx   context.Context
+	base  *url.URL
+	fetch FetchFunc
+	dir   string
+	saved map[string]string // 绝对 URL -> 相对于章节文件的路径，下载失败记为空串
+}
+
+func newRemoteImages(ctx context.Context, sourceURL string, fetch FetchFunc, dir string) *remoteImages {
+	ri := &remoteImages{ctx: ctx, fetch: fetch, dir: dir, saved: make(map[string]string)}
+	if u, err := url.Parse(sourceURL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		ri.base = u
+	}
+	return ri
+}
+
+// save 下载相对路径图片，返回新的 src；绝对地址、data URI 或下载失败时返回空串（保持原样）
+func (ri *remoteImages) save(ref string) string {
+	if ri.base == nil || ri.fetch == nil || ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+	abs := ri.base.ResolveReference(u)
+	abs.Fragment = ""
+	key := abs.String()
+	if src, ok := ri.saved[key]; ok {
+		return src
+	}
+	ri.saved[key] = ""
+	data, err := ri.fetch(ri.ctx, key)
+	if err != nil {
+		return ""
+	}
+	ext := strings.ToLower(path.Ext(abs.Path))
+	if !comicImageExts[ext] && ext != ".svg" {
+		if ext = imageExt(data); ext == "" {
+			return ""
+		}
+	}
+	file := fmt.Sprintf("%04d%s", len(ri.saved), ext)
+	if err := os.MkdirAll(ri.dir, 0755); err != nil {
+		return ""
+	}
+	if err := os.WriteFile(filepath.Join(ri.dir, file), data, 0644); err != nil {
+		return ""
+	}
+	ri.saved[key] = "../images/" + file
+	return ri.saved[key]
+}
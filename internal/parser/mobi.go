@@ -0,0 +1,785 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"ebook-reader/internal/model"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// MOBIParser Kindle 电子书解析器，支持旧版 MOBI (MOBI6)、AZW 以及 AZW3 (KF8)，
+// 包括同时包含两种格式的混合文件（优先使用 KF8 部分）
+type MOBIParser struct{}
+
+func init() {
+	Register(Format{
+		Name:         "mobi",
+		Extensions:   []string{".mobi", ".azw", ".azw3", ".prc"},
+		MIMETypes:    []string{"application/x-mobipocket-ebook", "application/vnd.amazon.ebook"},
+		Capabilities: Capabilities{Resources: true, Cover: true, TOCTree: true, SearchText: true},
+		// PalmDB 头部 60 偏移处的类型与创建者
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if len(s.Head) >= 68 && (string(s.Head[60:68]) == "BOOKMOBI" || string(s.Head[60:68]) == "TEXtREAd") {
+				return "", SniffExact
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &MOBIParser{} },
+	})
+}
+
+// DRMError 电子书受 DRM 保护，无法解析
+type DRMError struct {
+	Format     string
+	Encryption int // PalmDOC 头中的加密类型
+}
+
+func (e *DRMError) Error() string {
+	return fmt.Sprintf("%s: DRM protected (encryption type %d)", e.Format, e.Encryption)
+}
+
+const mobiNull = 0xFFFFFFFF
+
+// mobiHeader 记录 0（或 KF8 起始记录）中的 PalmDOC + MOBI + EXTH 头
+type mobiHeader struct {
+	compression uint16
+	textLength  uint32
+	textRecords int
+	encryption  uint16
+	encoding    uint32 // 1252 或 65001
+	version     uint32
+	fullName    []byte
+	firstImage  uint32
+	huffRecord  uint32
+	huffCount   uint32
+	extraFlags  uint16
+	fdst        uint32
+	ncx         uint32
+	frag        uint32
+	skel        uint32
+	exth        map[uint32][][]byte
+}
+
+// EXTH 记录类型
+const (
+	exthAuthor   = 100
+	exthCover    = 201
+	exthKF8      = 121 // KF8 起始记录号（混合文件）
+	exthTitle    = 503
+	exthBoundary = "BOUNDARY"
+)
+
+var (
+	mobiPagebreakRe = regexp.MustCompile(`(?i)<mbp:pagebreak\s*/?>`)
+	mobiFileposRe   = regexp.MustCompile(`(?i)\bfilepos\s*=\s*["']?0*(\d+)["']?`)
+	mobiRecindexRe  = regexp.MustCompile(`(?i)\b(?:hi|lo)?recindex\s*=\s*["']?(\d+)["']?`)
+	mobiFrameRe     = regexp.MustCompile(`(?is)<head[\s>].*?</head>|</?(?:html|body)(?:\s[^>]*)?>`)
+	mobiHeadingRe   = regexp.MustCompile(`(?is)<h[1-6][^>]*>(.*?)</h[1-6]>`)
+	mobiTagRe       = regexp.MustCompile(`<[^>]*>`)
+	mobiImgTagRe    = regexp.MustCompile(`(?i)<(?:img|image)\b`)
+
+	kindleEmbedRe = regexp.MustCompile(`kindle:embed:([0-9A-Va-v]+)(?:\?[^"')]*)?`)
+	kindleFlowRe  = regexp.MustCompile(`kindle:flow:([0-9A-Va-v]+)(?:\?mime=([^"')]*))?`)
+	kindlePosRe   = regexp.MustCompile(`href\s*=\s*["']kindle:pos:fid:([0-9A-Va-v]+):off:([0-9A-Va-v]+)(?:\?[^"']*)?["']`)
+	mobiIDAttrRe  = regexp.MustCompile(`\sid\s*=\s*["']([^"']+)["']`)
+)
+
+func (p *MOBIParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("open mobi: %w", err)
+	}
+	defer f.Close()
+
+	db, err := openPalmDB(f)
+	if err != nil {
+		return nil, err
+	}
+	rec0, err := db.record(0)
+	if err != nil {
+		return nil, err
+	}
+	h, err := parseMOBIHeader(rec0)
+	if err != nil {
+		return nil, err
+	}
+	if h.encryption != 0 {
+		return nil, &DRMError{Format: "mobi", Encryption: int(h.encryption)}
+	}
+
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "mobi",
+		CachePath: cachePath,
+	}
+	if t := h.exthString(exthTitle); t != "" {
+		book.Title = t
+	} else if len(h.fullName) > 0 {
+		book.Title = h.decode(h.fullName)
+	}
+	var authors []string
+	for _, a := range h.exth[exthAuthor] {
+		if s := strings.TrimSpace(h.decode(a)); s != "" {
+			authors = append(authors, s)
+		}
+	}
+	if len(authors) > 0 {
+		book.Author = strings.Join(authors, ", ")
+	}
+
+	// 图片记录在混合文件中由两部分共用，序号相对于记录 0 的 first image index
+	w := &mobiWriter{
+		book:   book,
+		dir:    filepath.Join(cachePath, "chapters"),
+		images: make(map[int]string),
+	}
+	if err := w.extractImages(db, h.firstImage, filepath.Join(cachePath, "images")); err != nil {
+		return nil, err
+	}
+	if v, ok := h.exthInt(exthCover); ok && v != mobiNull {
+		if name, ok := w.images[int(v)+1]; ok {
+			book.CoverFilePath = filepath.Join(cachePath, "images", name)
+		}
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// KF8：独立的 AZW3（版本 8），或混合文件中 EXTH 121 指向的 KF8 部分
+	kf8, base := (*mobiHeader)(nil), 0
+	if h.version >= 8 {
+		kf8 = h
+	} else if v, ok := h.exthInt(exthKF8); ok && v != mobiNull {
+		if rec, err := db.record(int(v)); err == nil {
+			if k, err := parseMOBIHeader(rec); err == nil && k.version >= 8 {
+				kf8, base = k, int(v)
+			}
+		}
+	}
+	if kf8 != nil && kf8.skel != mobiNull && kf8.frag != mobiNull {
+		if kf8.encryption != 0 {
+			return nil, &DRMError{Format: "mobi", Encryption: int(kf8.encryption)}
+		}
+		text, err := readMOBIText(db, kf8, base)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.buildKF8(db, kf8, base, text); err != nil {
+			return nil, err
+		}
+	} else {
+		text, err := readMOBIText(db, h, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.buildMOBI6(db, h, text); err != nil {
+			return nil, err
+		}
+	}
+	if len(book.Chapters) == 0 {
+		return nil, errors.New("mobi: no content")
+	}
+	return book, nil
+}
+
+func (p *MOBIParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	return readChapterFile(book, chapterID, fileURL)
+}
+
+// openPalmDB 读取 PalmDB 头和记录偏移表
+func openPalmDB(f *os.File) (*palmDB, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 78)
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return nil, fmt.Errorf("mobi: read header: %w", err)
+	}
+	if string(head[60:68]) != "BOOKMOBI" {
+		return nil, fmt.Errorf("mobi: unsupported database type %q", head[60:68])
+	}
+	n := int(binary.BigEndian.Uint16(head[76:]))
+	table := make([]byte, n*8)
+	if _, err := f.ReadAt(table, 78); err != nil {
+		return nil, fmt.Errorf("mobi: read record list: %w", err)
+	}
+	db := &palmDB{f: f, size: info.Size(), offsets: make([]uint32, n)}
+	for i := range db.offsets {
+		db.offsets[i] = binary.BigEndian.Uint32(table[i*8:])
+	}
+	return db, nil
+}
+
+// parseMOBIHeader 解析 PalmDOC + MOBI 头及 EXTH 元数据
+func parseMOBIHeader(rec []byte) (*mobiHeader, error) {
+	if len(rec) < 24 || string(rec[16:20]) != "MOBI" {
+		return nil, errors.New("mobi: missing MOBI header")
+	}
+	end := min(16+int(binary.BigEndian.Uint32(rec[20:])), len(rec))
+	u32 := func(off int) uint32 {
+		if off+4 > end {
+			return mobiNull
+		}
+		return binary.BigEndian.Uint32(rec[off:])
+	}
+
+	h := &mobiHeader{
+		compression: binary.BigEndian.Uint16(rec[0:]),
+		textLength:  binary.BigEndian.Uint32(rec[4:]),
+		textRecords: int(binary.BigEndian.Uint16(rec[8:])),
+		encryption:  binary.BigEndian.Uint16(rec[12:]),
+		encoding:    u32(28),
+		version:     u32(36),
+		firstImage:  u32(108),
+		huffRecord:  u32(112),
+		huffCount:   u32(116),
+		ncx:         u32(244),
+		fdst:        mobiNull,
+		frag:        mobiNull,
+		skel:        mobiNull,
+	}
+	if h.version == mobiNull {
+		h.version = 0
+	}
+	if off, n := u32(84), u32(88); off != mobiNull && n != mobiNull && int(off)+int(n) <= len(rec) {
+		h.fullName = rec[off : off+n]
+	}
+	if end >= 244 {
+		h.extraFlags = binary.BigEndian.Uint16(rec[242:])
+	}
+	if h.version >= 8 {
+		h.fdst = u32(192)
+		h.frag = u32(248)
+		h.skel = u32(252)
+	}
+
+	h.exth = make(map[uint32][][]byte)
+	if u32(128)&0x40 != 0 && end+12 <= len(rec) && string(rec[end:end+4]) == "EXTH" {
+		count := int(binary.BigEndian.Uint32(rec[end+8:]))
+		pos := end + 12
+		for i := 0; i < count && pos+8 <= len(rec); i++ {
+			typ := binary.BigEndian.Uint32(rec[pos:])
+			size := int(binary.BigEndian.Uint32(rec[pos+4:]))
+			if size < 8 || pos+size > len(rec) {
+				break
+			}
+			h.exth[typ] = append(h.exth[typ], rec[pos+8:pos+size])
+			pos += size
+		}
+	}
+	return h, nil
+}
+
+// decode 按书籍编码将字节转为 UTF-8
+func (h *mobiHeader) decode(b []byte) string {
+	if h.encoding == 1252 {
+		s, err := charmap.Windows1252.NewDecoder().Bytes(b)
+		if err == nil {
+			return string(s)
+		}
+	}
+	return string(bytes.ToValidUTF8(b, []byte("\uFFFD")))
+}
+
+func (h *mobiHeader) exthString(typ uint32) string {
+	if v := h.exth[typ]; len(v) > 0 {
+		return strings.TrimSpace(h.decode(v[0]))
+	}
+	return ""
+}
+
+func (h *mobiHeader) exthInt(typ uint32) (uint32, bool) {
+	if v := h.exth[typ]; len(v) > 0 && len(v[0]) == 4 {
+		return binary.BigEndian.Uint32(v[0]), true
+	}
+	return 0, false
+}
+
+// readMOBIText 解压并拼接所有文本记录，base 为头所在的记录号
+func readMOBIText(db *palmDB, h *mobiHeader, base int) ([]byte, error) {
+	var huff *huffDecoder
+	switch h.compression {
+	case mobiCompressionNone, mobiCompressionPalmDOC:
+	case mobiCompressionHuff:
+		if h.huffRecord == mobiNull || h.huffCount == 0 {
+			return nil, errors.New("mobi: missing HUFF records")
+		}
+		first, err := db.record(base + int(h.huffRecord))
+		if err != nil {
+			return nil, err
+		}
+		var cdics [][]byte
+		for i := 1; i < int(h.huffCount); i++ {
+			rec, err := db.record(base + int(h.huffRecord) + i)
+			if err != nil {
+				return nil, err
+			}
+			cdics = append(cdics, rec)
+		}
+		if huff, err = newHuffDecoder(first, cdics); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("mobi: unsupported compression %d", h.compression)
+	}
+
+	var buf bytes.Buffer
+	for i := 1; i <= h.textRecords; i++ {
+		rec, err := db.record(base + i)
+		if err != nil {
+			return nil, err
+		}
+		rec = rec[:len(rec)-trailingSize(rec, h.extraFlags)]
+		switch h.compression {
+		case mobiCompressionNone:
+			buf.Write(rec)
+		case mobiCompressionPalmDOC:
+			buf.Write(palmDOCDecompress(rec))
+		case mobiCompressionHuff:
+			out, err := huff.decompress(rec)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(out)
+		}
+	}
+	text := buf.Bytes()
+	if int(h.textLength) < len(text) {
+		text = text[:h.textLength]
+	}
+	return text, nil
+}
+
+// mobiWriter 将解析出的章节写入缓存目录
+type mobiWriter struct {
+	book   *model.Book
+	dir    string
+	images map[int]string // 图片序号（从 1 开始，对应 recindex / kindle:embed）-> 文件名
+}
+
+// imageExt 按内容识别图片类型，返回扩展名，无法识别时返回空串
+func imageExt(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/bmp":
+		return ".bmp"
+	case "image/webp":
+		return ".webp"
+	}
+	return ""
+}
+
+// extractImages 将 first image index 之后的图片记录写入 imageDir
+func (w *mobiWriter) extractImages(db *palmDB, first uint32, imageDir string) error {
+	if first == mobiNull {
+		return nil
+	}
+	for i := int(first); i < len(db.offsets); i++ {
+		rec, err := db.record(i)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(rec, []byte(exthBoundary)) {
+			break
+		}
+		ext := imageExt(rec)
+		if ext == "" {
+			continue
+		}
+		if err := os.MkdirAll(imageDir, 0755); err != nil {
+			return err
+		}
+		idx := i - int(first) + 1
+		name := fmt.Sprintf("%05d%s", idx, ext)
+		if err := os.WriteFile(filepath.Join(imageDir, name), rec, 0644); err != nil {
+			return fmt.Errorf("write image: %w", err)
+		}
+		w.images[idx] = name
+	}
+	return nil
+}
+
+// writeChapter 写入一章并登记到目录
+func (w *mobiWriter) writeChapter(title string, level int, content string) error {
+	id := len(w.book.Chapters)
+	if title == "" {
+		title = fmt.Sprintf("Chapter %d", id+1)
+	}
+	path := filepath.Join(w.dir, fmt.Sprintf("%05d.html", id))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("write chapter: %w", err)
+	}
+	w.book.Chapters = append(w.book.Chapters, model.Chapter{
+		ID:       id,
+		Title:    title,
+		Level:    level,
+		FilePath: path,
+	})
+	return nil
+}
+
+// mobiTOCEntry NCX 目录项，pos 为文本中的字节偏移
+type mobiTOCEntry struct {
+	pos   int
+	label string
+	depth int
+}
+
+// readNCX 读取 NCX 目录索引，fragPos 把 KF8 的 (fragment, offset) 换算为文本偏移
+func readNCX(db *palmDB, h *mobiHeader, base int, fragPos func(frag, off uint32) (int, bool)) []mobiTOCEntry {
+	if h.ncx == mobiNull {
+		return nil
+	}
+	entries, cncx, err := readMOBIIndex(db, base+int(h.ncx))
+	if err != nil {
+		return nil
+	}
+	var toc []mobiTOCEntry
+	for _, e := range entries {
+		pos := -1
+		if fid, ok := e.tag(6, 0); ok && fragPos != nil {
+			off, _ := e.tag(6, 1)
+			if p, ok := fragPos(fid, off); ok {
+				pos = p
+			}
+		}
+		if pos < 0 {
+			if v, ok := e.tag(1, 0); ok {
+				pos = int(v)
+			}
+		}
+		if pos < 0 {
+			continue
+		}
+		label := ""
+		if v, ok := e.tag(3, 0); ok {
+			label = strings.TrimSpace(h.decode([]byte(cncx[v])))
+		}
+		depth, _ := e.tag(4, 0)
+		toc = append(toc, mobiTOCEntry{pos: pos, label: label, depth: int(depth)})
+	}
+	return toc
+}
+
+// buildMOBI6 按 <mbp:pagebreak/> 切分旧版 MOBI 文本，filepos 链接改写为锚点
+func (w *mobiWriter) buildMOBI6(db *palmDB, h *mobiHeader, text []byte) error {
+	type chunk struct{ start, end, chapter int }
+	var chunks []chunk
+	start := 0
+	for _, m := range mobiPagebreakRe.FindAllIndex(text, -1) {
+		chunks = append(chunks, chunk{start: start, end: m[0]})
+		start = m[1]
+	}
+	chunks = append(chunks, chunk{start: start, end: len(text)})
+
+	// 跳过没有文字也没有图片的片段，章节号按保留的片段编号
+	next := 0
+	for i := range chunks {
+		c := &chunks[i]
+		body := text[c.start:c.end]
+		if len(bytes.TrimSpace(mobiTagRe.ReplaceAll(body, nil))) == 0 && !mobiImgTagRe.Match(body) {
+			c.chapter = -1
+			continue
+		}
+		c.chapter = next
+		next++
+	}
+	// chapterAt 返回偏移所在的章节，落在被跳过的片段时取其后的第一章
+	chapterAt := func(pos int) int {
+		i := sort.Search(len(chunks), func(i int) bool { return chunks[i].end > pos })
+		for ; i < len(chunks); i++ {
+			if chunks[i].chapter >= 0 {
+				return chunks[i].chapter
+			}
+		}
+		return max(next-1, 0)
+	}
+
+	targets := make(map[int]bool)
+	for _, m := range mobiFileposRe.FindAllSubmatch(text, -1) {
+		if pos, err := strconv.Atoi(string(m[1])); err == nil && pos <= len(text) {
+			targets[pos] = true
+		}
+	}
+	sorted := make([]int, 0, len(targets))
+	for pos := range targets {
+		sorted = append(sorted, pos)
+	}
+	sort.Ints(sorted)
+
+	toc := readNCX(db, h, 0, nil)
+
+	for _, c := range chunks {
+		if c.chapter < 0 {
+			continue
+		}
+		// 在链接目标处插入锚点；目标落在标签内部时移到标签开头
+		var buf bytes.Buffer
+		last := c.start
+		for _, pos := range sorted {
+			if pos < c.start || pos >= c.end {
+				continue
+			}
+			at := pos
+			if lt, gt := bytes.LastIndexByte(text[c.start:pos], '<'), bytes.LastIndexByte(text[c.start:pos], '>'); lt > gt {
+				at = c.start + lt
+			}
+			if at < last {
+				at = last
+			}
+			buf.Write(text[last:at])
+			fmt.Fprintf(&buf, `<a id="filepos%d"></a>`, pos)
+			last = at
+		}
+		buf.Write(text[last:c.end])
+
+		body := mobiFileposRe.ReplaceAllFunc(buf.Bytes(), func(m []byte) []byte {
+			pos, _ := strconv.Atoi(string(mobiFileposRe.FindSubmatch(m)[1]))
+			return fmt.Appendf(nil, `href="#filepos%d" data-chapter="%d"`, pos, chapterAt(pos))
+		})
+		body = mobiRecindexRe.ReplaceAllFunc(body, func(m []byte) []byte {
+			idx, _ := strconv.Atoi(string(mobiRecindexRe.FindSubmatch(m)[1]))
+			if name, ok := w.images[idx]; ok {
+				return []byte(`src="../images/` + name + `"`)
+			}
+			return nil
+		})
+		body = mobiFrameRe.ReplaceAll(body, nil)
+
+		title, level := "", 0
+		for _, e := range toc {
+			if e.pos >= c.start && e.pos < c.end && e.label != "" {
+				title, level = e.label, e.depth
+				break
+			}
+		}
+		content := h.decode(body)
+		if title == "" {
+			title = mobiHeadingTitle(content)
+		}
+		if err := w.writeChapter(title, level, `<div class="mobi-chapter">`+content+`</div>`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kf8Part KF8 中由骨架 + 片段拼出的一个 XHTML 文件，start 为其在原文中的起始偏移
+type kf8Part struct {
+	start int
+	data  []byte
+}
+
+type kf8Frag struct {
+	insertPos int
+	file      int
+}
+
+// buildKF8 按骨架/片段索引重组 KF8 的各个 XHTML 文件，每个文件为一章
+func (w *mobiWriter) buildKF8(db *palmDB, h *mobiHeader, base int, text []byte) error {
+	// FDST：第 0 个流为正文，其余为 CSS/SVG 等
+	flows := [][]byte{text}
+	if h.fdst != mobiNull {
+		if rec, err := db.record(base + int(h.fdst)); err == nil && len(rec) >= 12 && string(rec[:4]) == "FDST" {
+			off := int(binary.BigEndian.Uint32(rec[4:]))
+			n := int(binary.BigEndian.Uint32(rec[8:]))
+			var fs [][]byte
+			for i := 0; i < n && off+i*8+8 <= len(rec); i++ {
+				s := int(binary.BigEndian.Uint32(rec[off+i*8:]))
+				e := int(binary.BigEndian.Uint32(rec[off+i*8+4:]))
+				if s > e || e > len(text) {
+					break
+				}
+				fs = append(fs, text[s:e])
+			}
+			if len(fs) > 0 {
+				flows = fs
+			}
+		}
+	}
+
+	skels, _, err := readMOBIIndex(db, base+int(h.skel))
+	if err != nil {
+		return fmt.Errorf("mobi: skeleton index: %w", err)
+	}
+	fragEntries, _, err := readMOBIIndex(db, base+int(h.frag))
+	if err != nil {
+		return fmt.Errorf("mobi: fragment index: %w", err)
+	}
+
+	main := flows[0]
+	var parts []kf8Part
+	var frags []kf8Frag
+	fragPtr := 0
+	for _, s := range skels {
+		count, _ := s.tag(1, 0)
+		skelPos, _ := s.tag(6, 0)
+		skelLen, _ := s.tag(6, 1)
+		// 按 int64 相加，避免 uint32 溢出绕过检查
+		if int64(skelPos)+int64(skelLen) > int64(len(main)) {
+			return errors.New("mobi: skeleton out of range")
+		}
+		skeleton := append([]byte(nil), main[skelPos:skelPos+skelLen]...)
+		basePtr := int(skelPos) + int(skelLen)
+		for i := 0; i < int(count) && fragPtr < len(fragEntries); i++ {
+			e := fragEntries[fragPtr]
+			fragPtr++
+			insertPos, _ := strconv.Atoi(e.ident)
+			length, _ := e.tag(6, 1)
+			frags = append(frags, kf8Frag{insertPos: insertPos, file: len(parts)})
+			if int64(basePtr)+int64(length) > int64(len(main)) {
+				return errors.New("mobi: fragment out of range")
+			}
+			if insertPos < 0 {
+				// 插入位置损坏：跳过该片段，链接到它时视为目标不存在
+				basePtr += int(length)
+				continue
+			}
+			at := insertPos - int(skelPos)
+			if at < 0 || at > len(skeleton) {
+				at = len(skeleton)
+			}
+			frag := main[basePtr : basePtr+int(length)]
+			skeleton = append(skeleton[:at], append(append([]byte(nil), frag...), skeleton[at:]...)...)
+			basePtr += int(length)
+		}
+		parts = append(parts, kf8Part{start: int(skelPos), data: skeleton})
+	}
+	if len(parts) == 0 {
+		return errors.New("mobi: empty skeleton index")
+	}
+
+	// partAt 返回原文偏移所在的文件及其文件内偏移
+	partAt := func(pos int) (int, int) {
+		i := sort.Search(len(parts), func(i int) bool { return parts[i].start > pos }) - 1
+		i = max(i, 0)
+		return i, pos - parts[i].start
+	}
+	fragPos := func(fid, off uint32) (int, bool) {
+		if int(fid) >= len(frags) || frags[fid].insertPos < 0 {
+			return 0, false
+		}
+		return frags[fid].insertPos + int(off), true
+	}
+
+	// 其余流写成独立文件，供 kindle:flow 引用
+	flowDir := filepath.Join(w.book.CachePath, "flows")
+	flowNames := make(map[int]string)
+	for _, m := range kindleFlowRe.FindAllSubmatch(main, -1) {
+		idx, err := strconv.ParseInt(string(m[1]), 32, 32)
+		if err != nil || idx <= 0 || int(idx) >= len(flows) || flowNames[int(idx)] != "" {
+			continue
+		}
+		ext := ".css"
+		if strings.Contains(string(m[2]), "svg") {
+			ext = ".svg"
+		}
+		if err := os.MkdirAll(flowDir, 0755); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("flow%d%s", idx, ext)
+		if err := os.WriteFile(filepath.Join(flowDir, name), flows[idx], 0644); err != nil {
+			return fmt.Errorf("write flow: %w", err)
+		}
+		flowNames[int(idx)] = name
+	}
+
+	toc := readNCX(db, h, base, fragPos)
+	titles := make(map[int]mobiTOCEntry)
+	for _, e := range toc {
+		file, _ := partAt(e.pos)
+		if _, ok := titles[file]; !ok && e.label != "" {
+			titles[file] = e
+		}
+	}
+
+	for i, part := range parts {
+		data := kindleEmbedRe.ReplaceAllFunc(part.data, func(m []byte) []byte {
+			idx, _ := strconv.ParseInt(string(kindleEmbedRe.FindSubmatch(m)[1]), 32, 32)
+			if name, ok := w.images[int(idx)]; ok {
+				return []byte("../images/" + name)
+			}
+			return m
+		})
+		data = kindleFlowRe.ReplaceAllFunc(data, func(m []byte) []byte {
+			idx, _ := strconv.ParseInt(string(kindleFlowRe.FindSubmatch(m)[1]), 32, 32)
+			if name, ok := flowNames[int(idx)]; ok {
+				return []byte("../flows/" + name)
+			}
+			return m
+		})
+		data = kindlePosRe.ReplaceAllFunc(data, func(m []byte) []byte {
+			sub := kindlePosRe.FindSubmatch(m)
+			fid, _ := strconv.ParseInt(string(sub[1]), 32, 64)
+			off, _ := strconv.ParseInt(string(sub[2]), 32, 64)
+			pos, ok := fragPos(uint32(fid), uint32(off))
+			if !ok {
+				return []byte(`href="#"`)
+			}
+			file, at := partAt(pos)
+			return fmt.Appendf(nil, `href="#%s" data-chapter="%d"`, kf8AnchorBefore(parts[file].data, at), file)
+		})
+
+		content := string(data)
+		e := titles[i]
+		title := e.label
+		if title == "" {
+			title = mobiHeadingTitle(content)
+		}
+		if err := w.writeChapter(title, e.depth, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kf8AnchorBefore 返回文件内偏移 at 处（或之前最近的）带 id 的标签的 id，找不到时返回空串
+func kf8AnchorBefore(data []byte, at int) string {
+	at = min(max(at, 0), len(data))
+	// at 指向标签开头或落在标签内部时，把整个标签算进去
+	if gt, lt := bytes.IndexByte(data[at:], '>'), bytes.IndexByte(data[at:], '<'); gt >= 0 && (lt <= 0 || gt < lt) {
+		at += gt + 1
+	}
+	for end := at; end > 0; {
+		lt := bytes.LastIndexByte(data[:end], '<')
+		if lt < 0 {
+			break
+		}
+		tagEnd := bytes.IndexByte(data[lt:], '>')
+		if tagEnd >= 0 {
+			if m := mobiIDAttrRe.FindSubmatch(data[lt : lt+tagEnd+1]); m != nil {
+				return string(m[1])
+			}
+		}
+		end = lt
+	}
+	return ""
+}
+
+// mobiHeadingTitle 取章节中第一个 h1-h6 标题的文字
+func mobiHeadingTitle(content string) string {
+	m := mobiHeadingRe.FindStringSubmatch(content)
+	if m == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(mobiTagRe.ReplaceAllString(m[1], ""))), " ")
+}
@@ -0,0 +1,216 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MOBI 文本记录压缩方式
+const (
+	mobiCompressionNone    = 1
+	mobiCompressionPalmDOC = 2
+	mobiCompressionHuff    = 17480 // "DH"
+)
+
+// trailingSize 计算文本记录末尾附加数据的长度（由 MOBI 头的 extra data flags 决定）
+func trailingSize(data []byte, flags uint16) int {
+	size := 0
+	for f := flags >> 1; f != 0; f >>= 1 {
+		if size >= len(data) {
+			// 声明的附加数据已超出记录长度，记录损坏
+			return len(data)
+		}
+		if f&1 != 0 {
+			size += backwardInt(data[:len(data)-size])
+		}
+	}
+	// 最低位：多字节字符跨记录的重叠字节
+	if flags&1 != 0 && len(data) > size {
+		size += int(data[len(data)-size-1]&0x3) + 1
+	}
+	return min(size, len(data))
+}
+
+// backwardInt 从末尾向前读取变长整数（每字节 7 位，最高位为 1 的字节是最后读取的字节）
+func backwardInt(data []byte) int {
+	result, shift := 0, 0
+	for i := len(data) - 1; i >= 0; i-- {
+		v := data[i]
+		result |= int(v&0x7F) << shift
+		shift += 7
+		if v&0x80 != 0 || shift >= 28 {
+			break
+		}
+	}
+	return result
+}
+
+// palmDOCDecompress 解压 PalmDOC (LZ77 变种) 压缩的记录
+func palmDOCDecompress(in []byte) []byte {
+	out := make([]byte, 0, len(in)*2)
+	for i := 0; i < len(in); {
+		c := in[i]
+		i++
+		switch {
+		case c >= 1 && c <= 8:
+			// 后面 c 个字节原样复制
+			end := min(i+int(c), len(in))
+			out = append(out, in[i:end]...)
+			i = end
+		case c < 0x80:
+			out = append(out, c)
+		case c >= 0xC0:
+			// 空格 + 字符
+			out = append(out, ' ', c^0x80)
+		default:
+			// 回溯复制：高 11 位为距离，低 3 位 + 3 为长度
+			if i >= len(in) {
+				return out
+			}
+			pair := int(c)<<8 | int(in[i])
+			i++
+			dist := (pair & 0x3FFF) >> 3
+			n := pair&7 + 3
+			if dist == 0 || dist > len(out) {
+				continue
+			}
+			for j := 0; j < n; j++ {
+				out = append(out, out[len(out)-dist])
+			}
+		}
+	}
+	return out
+}
+
+// huffDecoder HUFF/CDIC 压缩的解码器
+type huffDecoder struct {
+	dict1   [256]huffCode
+	mincode [33]uint64
+	maxcode [33]uint64
+	phrases []huffPhrase
+}
+
+type huffCode struct {
+	codelen uint
+	term    bool
+	maxcode uint64
+}
+
+type huffPhrase struct {
+	data     []byte
+	resolved bool // 已展开（不再需要递归解码）
+}
+
+// newHuffDecoder 由 HUFF 记录和后续的 CDIC 记录构建解码器
+func newHuffDecoder(huff []byte, cdics [][]byte) (*huffDecoder, error) {
+	if len(huff) < 16 || string(huff[:8]) != "HUFF\x00\x00\x00\x18" {
+		return nil, errors.New("mobi: invalid HUFF record")
+	}
+	off1 := int(binary.BigEndian.Uint32(huff[8:]))
+	off2 := int(binary.BigEndian.Uint32(huff[12:]))
+	if off1+256*4 > len(huff) || off2+64*4 > len(huff) {
+		return nil, errors.New("mobi: truncated HUFF record")
+	}
+
+	h := &huffDecoder{}
+	for i := 0; i < 256; i++ {
+		v := binary.BigEndian.Uint32(huff[off1+i*4:])
+		codelen := uint(v & 0x1F)
+		if codelen == 0 {
+			return nil, errors.New("mobi: invalid HUFF code length")
+		}
+		h.dict1[i] = huffCode{
+			codelen: codelen,
+			term:    v&0x80 != 0,
+			maxcode: ((uint64(v>>8) + 1) << (32 - codelen)) - 1,
+		}
+	}
+	for codelen := uint(1); codelen <= 32; codelen++ {
+		lo := binary.BigEndian.Uint32(huff[off2+int(codelen-1)*8:])
+		hi := binary.BigEndian.Uint32(huff[off2+int(codelen-1)*8+4:])
+		h.mincode[codelen] = uint64(lo) << (32 - codelen)
+		h.maxcode[codelen] = ((uint64(hi) + 1) << (32 - codelen)) - 1
+	}
+
+	for _, cdic := range cdics {
+		if len(cdic) < 16 || string(cdic[:8]) != "CDIC\x00\x00\x00\x10" {
+			return nil, errors.New("mobi: invalid CDIC record")
+		}
+		total := int(binary.BigEndian.Uint32(cdic[8:]))
+		bits := uint(binary.BigEndian.Uint32(cdic[12:]))
+		n := min(1<<bits, total-len(h.phrases))
+		for i := 0; i < n; i++ {
+			if 16+i*2+2 > len(cdic) {
+				return nil, errors.New("mobi: truncated CDIC record")
+			}
+			off := int(binary.BigEndian.Uint16(cdic[16+i*2:]))
+			if 16+off+2 > len(cdic) {
+				return nil, errors.New("mobi: truncated CDIC record")
+			}
+			blen := binary.BigEndian.Uint16(cdic[16+off:])
+			start := 18 + off
+			end := min(start+int(blen&0x7FFF), len(cdic))
+			h.phrases = append(h.phrases, huffPhrase{data: cdic[start:end], resolved: blen&0x8000 != 0})
+		}
+	}
+	return h, nil
+}
+
+// decompress 解码一条 HUFF 压缩的记录
+func (h *huffDecoder) decompress(data []byte) ([]byte, error) {
+	return h.unpack(data, 0)
+}
+
+func (h *huffDecoder) unpack(data []byte, depth int) ([]byte, error) {
+	if depth > 32 {
+		return nil, errors.New("mobi: HUFF recursion too deep")
+	}
+	bitsLeft := len(data) * 8
+	// 末尾补 8 字节，保证总能读出 64 位窗口
+	buf := make([]byte, len(data)+8)
+	copy(buf, data)
+
+	var out []byte
+	pos := 0
+	x := binary.BigEndian.Uint64(buf[pos:])
+	n := 32
+	for {
+		if n <= 0 {
+			pos += 4
+			if pos+8 > len(buf) {
+				break
+			}
+			x = binary.BigEndian.Uint64(buf[pos:])
+			n += 32
+		}
+		code := (x >> uint(n)) & 0xFFFFFFFF
+		c := h.dict1[code>>24]
+		codelen, maxcode := c.codelen, c.maxcode
+		if !c.term {
+			for codelen < 32 && code < h.mincode[codelen] {
+				codelen++
+			}
+			maxcode = h.maxcode[codelen]
+		}
+		n -= int(codelen)
+		bitsLeft -= int(codelen)
+		if bitsLeft < 0 {
+			break
+		}
+		r := int((maxcode - code) >> (32 - codelen))
+		if r < 0 || r >= len(h.phrases) {
+			return nil, fmt.Errorf("mobi: HUFF phrase %d out of range", r)
+		}
+		p := &h.phrases[r]
+		if !p.resolved {
+			expanded, err := h.unpack(p.data, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			p.data, p.resolved = expanded, true
+		}
+		out = append(out, p.data...)
+	}
+	return out, nil
+}
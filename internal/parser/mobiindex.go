@@ -0,0 +1,255 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// mobiIndexEntry INDX 索引中的一条记录：标识文本 + 标签值
+type mobiIndexEntry struct {
+	ident string
+	tags  map[byte][]uint32
+}
+
+// tag 返回指定标签的第 i 个值
+func (e mobiIndexEntry) tag(t byte, i int) (uint32, bool) {
+	v := e.tags[t]
+	if i >= len(v) {
+		return 0, false
+	}
+	return v[i], true
+}
+
+type tagxEntry struct {
+	tag       byte
+	numValues byte
+	bitmask   byte
+	eof       byte
+}
+
+// readMOBIIndex 读取从 idx 开始的 INDX 索引（主记录 + 数据记录 + CNCX 字符串记录）
+func readMOBIIndex(r *palmDB, idx int) ([]mobiIndexEntry, map[uint32]string, error) {
+	head, err := r.record(idx)
+	if err != nil {
+		return nil, nil, err
+	}
+	hdr, err := parseINDXHeader(head)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int(hdr.tagx)+12 > len(head) || string(head[hdr.tagx:hdr.tagx+4]) != "TAGX" {
+		return nil, nil, errors.New("mobi: missing TAGX")
+	}
+	controlBytes, tagx := parseTAGX(head[hdr.tagx:])
+
+	cncx := make(map[uint32]string)
+	for i := 0; i < int(hdr.ncncx); i++ {
+		rec, err := r.record(idx + int(hdr.count) + 1 + i)
+		if err != nil {
+			return nil, nil, err
+		}
+		parseCNCX(rec, uint32(i)*0x10000, cncx)
+	}
+
+	var entries []mobiIndexEntry
+	for i := 1; i <= int(hdr.count); i++ {
+		data, err := r.record(idx + i)
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err := parseINDXHeader(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		idxt := int(h.start)
+		if idxt+4+int(h.count)*2 > len(data) {
+			return nil, nil, errors.New("mobi: truncated IDXT")
+		}
+		positions := make([]int, 0, h.count+1)
+		for j := 0; j < int(h.count); j++ {
+			positions = append(positions, int(binary.BigEndian.Uint16(data[idxt+4+j*2:])))
+		}
+		positions = append(positions, idxt)
+		for j := 0; j < int(h.count); j++ {
+			start, end := positions[j], positions[j+1]
+			if start >= end || end > len(data) {
+				continue
+			}
+			rec := data[start:end]
+			n := int(rec[0])
+			if 1+n > len(rec) {
+				continue
+			}
+			entry := mobiIndexEntry{ident: string(rec[1 : 1+n])}
+			entry.tags = parseTagMap(controlBytes, tagx, rec[1+n:])
+			entries = append(entries, entry)
+		}
+	}
+	return entries, cncx, nil
+}
+
+type indxHeader struct {
+	start uint32 // IDXT 偏移
+	count uint32 // 主记录中为数据记录数，数据记录中为条目数
+	ncncx uint32 // CNCX 记录数
+	tagx  uint32 // TAGX 偏移（仅主记录）
+}
+
+func parseINDXHeader(data []byte) (indxHeader, error) {
+	if len(data) < 184 || string(data[:4]) != "INDX" {
+		return indxHeader{}, errors.New("mobi: invalid INDX record")
+	}
+	return indxHeader{
+		start: binary.BigEndian.Uint32(data[20:]),
+		count: binary.BigEndian.Uint32(data[24:]),
+		ncncx: binary.BigEndian.Uint32(data[52:]),
+		tagx:  binary.BigEndian.Uint32(data[180:]),
+	}, nil
+}
+
+func parseTAGX(data []byte) (int, []tagxEntry) {
+	firstEntry := int(binary.BigEndian.Uint32(data[4:]))
+	controlBytes := int(binary.BigEndian.Uint32(data[8:]))
+	var tags []tagxEntry
+	for i := 12; i+4 <= firstEntry && i+4 <= len(data); i += 4 {
+		tags = append(tags, tagxEntry{tag: data[i], numValues: data[i+1], bitmask: data[i+2], eof: data[i+3]})
+	}
+	return controlBytes, tags
+}
+
+// parseTagMap 按 TAGX 描述解析条目的控制字节和变长整数值
+func parseTagMap(controlBytes int, tagx []tagxEntry, data []byte) map[byte][]uint32 {
+	if controlBytes > len(data) {
+		return nil
+	}
+	ctrl := data[:controlBytes]
+	data = data[controlBytes:]
+
+	type pending struct {
+		tag        byte
+		valueCount int // 值的组数，-1 表示按字节数读取
+		valueBytes int
+		numValues  int
+	}
+	var ptags []pending
+	for _, t := range tagx {
+		if t.eof == 1 {
+			if len(ctrl) > 0 {
+				ctrl = ctrl[1:]
+			}
+			continue
+		}
+		if len(ctrl) == 0 {
+			break
+		}
+		value := ctrl[0] & t.bitmask
+		if value == 0 {
+			continue
+		}
+		p := pending{tag: t.tag, numValues: int(t.numValues), valueCount: -1}
+		if value == t.bitmask {
+			if bitsSet(t.bitmask) > 1 {
+				// 掩码多位全置位：后跟一个变长整数，表示值占用的字节数
+				v, n := forwardInt(data)
+				data = data[n:]
+				p.valueBytes = int(v)
+			} else {
+				p.valueCount = 1
+			}
+		} else {
+			mask := t.bitmask
+			for mask&1 == 0 {
+				mask >>= 1
+				value >>= 1
+			}
+			p.valueCount = int(value)
+		}
+		ptags = append(ptags, p)
+	}
+
+	tags := make(map[byte][]uint32)
+	for _, p := range ptags {
+		var values []uint32
+		if p.valueCount >= 0 {
+			for i := 0; i < p.valueCount*p.numValues && len(data) > 0; i++ {
+				v, n := forwardInt(data)
+				data = data[n:]
+				values = append(values, v)
+			}
+		} else {
+			consumed := 0
+			for consumed < p.valueBytes && len(data) > 0 {
+				v, n := forwardInt(data)
+				data = data[n:]
+				consumed += n
+				values = append(values, v)
+			}
+		}
+		tags[p.tag] = values
+	}
+	return tags
+}
+
+// parseCNCX 解析 CNCX 字符串记录，键为记录序号 * 0x10000 + 记录内偏移
+func parseCNCX(data []byte, base uint32, out map[uint32]string) {
+	for pos := 0; pos < len(data); {
+		length, n := forwardInt(data[pos:])
+		if n == 0 {
+			break
+		}
+		if length > 0 {
+			end := min(pos+n+int(length), len(data))
+			out[base+uint32(pos)] = string(data[pos+n : end])
+		}
+		pos += n + int(length)
+	}
+}
+
+// forwardInt 从前向后读取变长整数（每字节 7 位，最高位为 1 的字节结束）
+func forwardInt(data []byte) (uint32, int) {
+	var v uint32
+	for i, b := range data {
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 != 0 {
+			return v, i + 1
+		}
+	}
+	return v, len(data)
+}
+
+func bitsSet(b byte) int {
+	n := 0
+	for ; b != 0; b &= b - 1 {
+		n++
+	}
+	return n
+}
+
+// palmDB PalmDB 容器：按需读取各条记录
+type palmDB struct {
+	f       io.ReaderAt
+	size    int64
+	offsets []uint32
+}
+
+// record 读取第 i 条记录
+func (r *palmDB) record(i int) ([]byte, error) {
+	if i < 0 || i >= len(r.offsets) {
+		return nil, fmt.Errorf("mobi: record %d out of range", i)
+	}
+	start := int64(r.offsets[i])
+	end := r.size
+	if i+1 < len(r.offsets) {
+		end = int64(r.offsets[i+1])
+	}
+	if start > end || end > r.size {
+		return nil, fmt.Errorf("mobi: invalid record %d", i)
+	}
+	buf := make([]byte, end-start)
+	if _, err := r.f.ReadAt(buf, start); err != nil {
+		return nil, fmt.Errorf("mobi: read record %d: %w", i, err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,343 @@
+package parser
+
+import (
+	"context"
+	"ebook-reader/internal/model"
+	"errors"
+	"fmt"
+	"html"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ODTParser OpenDocument 文本 (ODF) 解析器，按 text:h 标题切分章节
+type ODTParser struct{}
+
+func init() {
+	Register(Format{
+		Name:         "odt",
+		Extensions:   []string{".odt"},
+		MIMETypes:    []string{"application/vnd.oasis.opendocument.text"},
+		Capabilities: Capabilities{Resources: true, Cover: true, TOCTree: true, SearchText: true},
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if s.Mimetype == "application/vnd.oasis.opendocument.text" {
+				return "", SniffExact
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &ODTParser{} },
+	})
+}
+
+// odtStyle 自动样式中用到的属性
+type odtStyle struct {
+	bold, italic bool
+	ordered      map[string]bool // 列表样式：各层级（"1" 起）是否为编号
+	parent       string
+}
+
+// odtConverter 把 content.xml 的节点树渲染为 HTML
+type odtConverter struct {
+	doc    *zipDoc
+	w      *docWriter
+	styles map[string]odtStyle
+}
+
+func (p *ODTParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	doc, err := openZipDoc(filePath, cachePath)
+	if err != nil {
+		return nil, fmt.Errorf("open odt: %w", err)
+	}
+	defer doc.Close()
+
+	root, err := doc.tree("content.xml")
+	if err != nil {
+		return nil, err
+	}
+	var text *xmlNode
+	if root != nil {
+		if body := root.find("body"); body != nil {
+			text = body.child("text")
+		}
+	}
+	if text == nil {
+		return nil, errors.New("odt: missing office:text in content.xml")
+	}
+
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "odt",
+		CachePath: cachePath,
+	}
+	meta, err := doc.tree("meta.xml")
+	if err != nil {
+		return nil, err
+	}
+	if title, author := coreProps(meta); title != "" || author != "" {
+		if title != "" {
+			book.Title = title
+		}
+		if author != "" {
+			book.Author = author
+		}
+	}
+	if thumb := doc.image("Thumbnails/thumbnail.png"); thumb != "" {
+		book.CoverFilePath = filepath.Join(doc.imageDir, path.Base(thumb))
+	}
+
+	c := &odtConverter{doc: doc, styles: make(map[string]odtStyle)}
+	// styles.xml 中的公共样式可被自动样式继承
+	styles, err := doc.tree("styles.xml")
+	if err != nil {
+		return nil, err
+	}
+	c.loadStyles(styles)
+	c.loadStyles(root)
+	if c.w, err = newDocWriter(book, "doc-chapter"); err != nil {
+		return nil, err
+	}
+	if err := c.blocks(text); err != nil {
+		return nil, err
+	}
+	if err := c.w.flush(); err != nil {
+		return nil, err
+	}
+	if len(book.Chapters) == 0 {
+		return nil, errors.New("odt: document is empty")
+	}
+	return book, nil
+}
+
+func (p *ODTParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	return readChapterFile(book, chapterID, fileURL)
+}
+
+// loadStyles 收集 style:style 的粗体/斜体属性和 text:list-style 的编号类型
+func (c *odtConverter) loadStyles(root *xmlNode) {
+	if root == nil {
+		return
+	}
+	var walk func(*xmlNode)
+	walk = func(n *xmlNode) {
+		for _, s := range n.children {
+			switch s.name {
+			case "style":
+				st := odtStyle{parent: s.attrs["parent-style-name"]}
+				if tp := s.child("text-properties"); tp != nil {
+					st.bold = tp.attrs["font-weight"] == "bold" || tp.attrs["font-weight"] == "700"
+					st.italic = tp.attrs["font-style"] == "italic" || tp.attrs["font-style"] == "oblique"
+				}
+				c.styles[s.attrs["name"]] = st
+			case "list-style":
+				st := odtStyle{ordered: make(map[string]bool)}
+				for _, lv := range s.children {
+					st.ordered[lv.attrs["level"]] = lv.name == "list-level-style-number"
+				}
+				c.styles[s.attrs["name"]] = st
+			case "styles", "automatic-styles", "document-styles", "document-content":
+				walk(s)
+			}
+		}
+	}
+	walk(root)
+}
+
+// format 沿 parent-style-name 链合并粗体/斜体
+func (c *odtConverter) format(name string) (bold, italic bool) {
+	for i := 0; name != "" && i < 10; i++ {
+		st, ok := c.styles[name]
+		if !ok {
+			break
+		}
+		bold, italic = bold || st.bold, italic || st.italic
+		name = st.parent
+	}
+	return bold, italic
+}
+
+// blocks 渲染 office:text / text:section 中的块级元素
+func (c *odtConverter) blocks(n *xmlNode) error {
+	for _, b := range n.children {
+		switch b.name {
+		case "h":
+			inner := strings.TrimSpace(c.inline(b))
+			if inner == "" {
+				continue
+			}
+			level, err := strconv.Atoi(b.attrs["outline-level"])
+			if err != nil || level < 1 {
+				level = 1
+			}
+			if err := c.w.heading(level, b.textContent(), inner); err != nil {
+				return err
+			}
+		case "p":
+			if inner := strings.TrimSpace(c.inline(b)); inner != "" {
+				bold, italic := c.format(b.attrs["style-name"])
+				c.w.write("<p>" + wrapFormat(inner, bold, italic) + "</p>")
+			}
+		case "list":
+			c.w.write(c.list(b, b.attrs["style-name"], 1))
+		case "table":
+			c.w.write(c.table(b))
+		case "section", "soft-page-break":
+			if err := c.blocks(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// list 渲染第 level 层的 text:list，嵌套列表未指定样式时沿用外层样式
+func (c *odtConverter) list(l *xmlNode, style string, level int) string {
+	if s := l.attrs["style-name"]; s != "" {
+		style = s
+	}
+	tag := "ul"
+	if c.styles[style].ordered[strconv.Itoa(level)] {
+		tag = "ol"
+	}
+	var buf strings.Builder
+	buf.WriteString("<" + tag + ">")
+	for _, item := range l.children {
+		if item.name != "list-item" && item.name != "list-header" {
+			continue
+		}
+		var parts []string
+		for _, b := range item.children {
+			switch b.name {
+			case "p", "h":
+				if s := strings.TrimSpace(c.inline(b)); s != "" {
+					parts = append(parts, s)
+				}
+			case "list":
+				parts = append(parts, c.list(b, style, level+1))
+			}
+		}
+		buf.WriteString("<li>" + strings.Join(parts, "<br>") + "</li>")
+	}
+	buf.WriteString("</" + tag + ">")
+	return buf.String()
+}
+
+func (c *odtConverter) table(t *xmlNode) string {
+	var buf strings.Builder
+	buf.WriteString(`<table class="doc-table">`)
+	var rows func(*xmlNode)
+	rows = func(n *xmlNode) {
+		for _, tr := range n.children {
+			switch tr.name {
+			case "table-header-rows", "table-rows", "table-row-group":
+				rows(tr)
+			case "table-row":
+				buf.WriteString("<tr>")
+				for _, td := range tr.children {
+					if td.name != "table-cell" {
+						continue
+					}
+					span := ""
+					if n := td.attrs["number-columns-spanned"]; n != "" && n != "1" {
+						span = fmt.Sprintf(` colspan="%s"`, html.EscapeString(n))
+					}
+					var cell []string
+					for _, b := range td.children {
+						switch b.name {
+						case "p", "h":
+							if s := strings.TrimSpace(c.inline(b)); s != "" {
+								cell = append(cell, s)
+							}
+						case "list":
+							cell = append(cell, c.list(b, "", 1))
+						case "table":
+							cell = append(cell, c.table(b))
+						}
+					}
+					buf.WriteString("<td" + span + ">" + strings.Join(cell, "<br>") + "</td>")
+				}
+				buf.WriteString("</tr>")
+			}
+		}
+	}
+	rows(t)
+	buf.WriteString("</table>")
+	return buf.String()
+}
+
+// inline 渲染段落内的文本、span、链接、空白和图片
+func (c *odtConverter) inline(n *xmlNode) string {
+	var buf strings.Builder
+	for _, e := range n.children {
+		switch e.name {
+		case "":
+			buf.WriteString(htmlEscape(e.text))
+		case "span":
+			bold, italic := c.format(e.attrs["style-name"])
+			buf.WriteString(wrapFormat(c.inline(e), bold, italic))
+		case "a":
+			inner := c.inline(e)
+			if href := linkHref(e.attrs["href"]); href != "" {
+				buf.WriteString(`<a href="` + html.EscapeString(href) + `">` + inner + `</a>`)
+			} else {
+				buf.WriteString(inner)
+			}
+		case "s":
+			count := 1
+			if n, err := strconv.Atoi(e.attrs["c"]); err == nil && n > 0 {
+				count = min(n, 100)
+			}
+			buf.WriteString(strings.Repeat("&nbsp;", count))
+		case "tab":
+			buf.WriteString(" ")
+		case "line-break":
+			buf.WriteString("<br>")
+		case "frame":
+			buf.WriteString(c.frame(e))
+		case "note":
+			// 脚注正文直接内联显示
+			if body := e.child("note-body"); body != nil {
+				if s := body.textContent(); s != "" {
+					buf.WriteString(`<small class="doc-note">(` + htmlEscape(s) + `)</small>`)
+				}
+			}
+		case "bookmark-ref", "reference-ref", "sequence", "date", "time", "page-number",
+			"title", "author-name", "chapter", "meta", "ruby-base":
+			buf.WriteString(c.inline(e))
+		}
+	}
+	return buf.String()
+}
+
+// frame 渲染 draw:frame 中的图片，文本框中的段落按行内文本处理
+func (c *odtConverter) frame(f *xmlNode) string {
+	alt := ""
+	if d := f.child("desc"); d != nil {
+		alt = d.textContent()
+	} else if t := f.child("title"); t != nil {
+		alt = t.textContent()
+	}
+	for _, e := range f.children {
+		switch e.name {
+		case "image":
+			href := e.attrs["href"]
+			if href == "" || strings.Contains(href, "://") {
+				continue
+			}
+			if src := c.doc.image(href); src != "" {
+				return fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(src), html.EscapeString(alt))
+			}
+		case "text-box":
+			var parts []string
+			for _, b := range e.children {
+				if s := strings.TrimSpace(c.inline(b)); s != "" {
+					parts = append(parts, s)
+				}
+			}
+			return strings.Join(parts, "<br>")
+		}
+	}
+	return ""
+}
@@ -1,35 +1,76 @@
 package parser
 
 import (
+	"context"
 	"ebook-reader/internal/model"
 	"fmt"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 // Parser 电子书解析器统一接口
 type Parser interface {
 	// Parse 解析电子书，cachePath 为解压/存储目录
-	Parse(filePath string, cachePath string) (*model.Book, error)
+	Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error)
 	// ReadChapter 按需读取章节内容，返回 HTML 字符串
 	// fileURL 用于改写资源路径中的 ?file= 参数
 	ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error)
 }
 
-// GetParser 根据文件扩展名或格式名返回对应的解析器
-func GetParser(nameOrFormat string) (Parser, error) {
-	s := strings.ToLower(nameOrFormat)
-	// 支持直接传格式名 "epub" 或文件路径 "xxx.epub"
-	ext := filepath.Ext(s)
-	if ext == "" {
-		ext = "." + s
+// 匹配 src="..." href="..." xlink:href="..." 中的相对路径资源引用
+var resourceAttrRe = regexp.MustCompile(`(?i)(src|href|xlink:href)\s*=\s*"([^"]*)"`)
+
+// urlSchemeRe 带协议的地址（http: / data: / mailto: 等），不是包内资源
+var urlSchemeRe = regexp.MustCompile(`(?i)^[a-z][a-z0-9+.\-]*:`)
+
+// rewriteResourceLinks 将章节 HTML 中的相对资源路径改写为 /api/book/resource/ 代理地址
+// chapterDir 为章节文件所在目录，相对路径据此解析为相对于 cachePath 的路径
+func rewriteResourceLinks(content string, chapterDir string, cachePath string, fileURL string) string {
+	return resourceAttrRe.ReplaceAllStringFunc(content, func(match string) string {
+		subs := resourceAttrRe.FindStringSubmatch(match)
+		if len(subs) < 3 {
+			return match
+		}
+		attr := subs[1]
+		val := subs[2]
+
+		// 跳过: 带协议的地址（data URI、绝对 URL、mailto 等）, 锚点链接, 空值
+		if val == "" || urlSchemeRe.MatchString(val) || strings.HasPrefix(val, "#") {
+			return match
+		}
+
+		// 对于 href 属性，只改写指向资源文件的（图片/CSS/字体），跳过 .xhtml/.html 章节链接
+		if strings.EqualFold(attr, "href") {
+			ext := strings.ToLower(filepath.Ext(val))
+			if ext == ".xhtml" || ext == ".html" || ext == ".htm" || ext == "" {
+				return match
+			}
+		}
+
+		// 解析相对路径为相对于 cachePath 的路径
+		absPath := filepath.Join(chapterDir, val)
+		relPath, err := filepath.Rel(cachePath, absPath)
+		if err != nil {
+			return match
+		}
+		// 统一用正斜杠
+		relPath = filepath.ToSlash(relPath)
+
+		return fmt.Sprintf(`%s="/api/book/resource/%s/%s"`, attr, fileURL, relPath)
+	})
+}
+
+// readChapterFile 读取解析时预先写入缓存目录的章节文件，并改写其中的资源路径
+func readChapterFile(book *model.Book, chapterID int, fileURL string) (string, error) {
+	if chapterID < 0 || chapterID >= len(book.Chapters) {
+		return "", fmt.Errorf("chapter %d out of range", chapterID)
 	}
-	switch ext {
-	case ".epub":
-		return &EPUBParser{}, nil
-	case ".txt":
-		return &TXTParser{}, nil
-	default:
-		return nil, fmt.Errorf("unsupported format: %s", ext)
+	ch := book.Chapters[chapterID]
+	data, err := os.ReadFile(ch.FilePath)
+	if err != nil {
+		return "", fmt.Errorf("read chapter file: %w", err)
 	}
+	return rewriteResourceLinks(string(data), filepath.Dir(ch.FilePath), book.CachePath, fileURL), nil
 }
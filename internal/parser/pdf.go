@@ -0,0 +1,435 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"ebook-reader/internal/model"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// PDFParser PDF 解析器：目录取自文档大纲，正文按页提取文字重排为 HTML。
+// 章节的 Offset 为起始页（从 1 开始），Length 为页数，阅读时按需提取
+type PDFParser struct{}
+
+func init() {
+	Register(Format{
+		Name:         "pdf",
+		Extensions:   []string{".pdf"},
+		MIMETypes:    []string{"application/pdf"},
+		Capabilities: Capabilities{TOCTree: true, SearchText: true},
+		// 规范允许文件头前有少量垃圾字节
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if bytes.Contains(s.Head, []byte("%PDF-")) {
+				return "", SniffExact
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &PDFParser{} },
+	})
+}
+
+// pdfOutlineItem 大纲中指向某一页的条目
+type pdfOutlineItem struct {
+	title string
+	page  int
+	level int
+}
+
+func (p *PDFParser) Parse(ctx context.Context, filePath string, cachePath string) (book *model.Book, err error) {
+	f, r, err := pdf.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("open pdf: %w", err)
+	}
+	defer f.Close()
+	// 第三方库遇到损坏的对象会 panic
+	defer func() {
+		if x := recover(); x != nil {
+			book, err = nil, fmt.Errorf("parse pdf: %v", x)
+		}
+	}()
+
+	pages := pdfPages(r)
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("pdf: no pages")
+	}
+
+	book = &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "pdf",
+		CachePath: cachePath,
+	}
+	root := r.Trailer().Key("Root")
+	title, author := pdfXMPMeta(root.Key("Metadata"))
+	info := r.Trailer().Key("Info")
+	if title == "" {
+		title = strings.TrimSpace(info.Key("Title").Text())
+	}
+	if author == "" {
+		author = strings.TrimSpace(info.Key("Author").Text())
+	}
+	if title != "" {
+		book.Title = title
+	}
+	if author != "" {
+		book.Author = author
+	}
+
+	// 页面字典的文本表示（含子对象引用）可唯一标识一页，用于把大纲目标映射到页码
+	pageNum := make(map[string]int, len(pages))
+	for i, pg := range pages {
+		pageNum[pg.V.String()] = i + 1
+	}
+	var items []pdfOutlineItem
+	collectPDFOutline(r, root.Key("Outlines"), 0, pageNum, len(pages), &items, 0)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].page < items[j].page })
+
+	// 同一页有多个大纲条目时只保留第一个；第一个条目之前的页面单独成章
+	var starts []pdfOutlineItem
+	for _, it := range items {
+		if len(starts) > 0 && starts[len(starts)-1].page == it.page {
+			continue
+		}
+		starts = append(starts, it)
+	}
+	if len(starts) == 0 {
+		// 没有大纲：每页一章
+		for i := range pages {
+			starts = append(starts, pdfOutlineItem{title: fmt.Sprintf("Page %d", i+1), page: i + 1})
+		}
+	} else if starts[0].page > 1 {
+		starts = append([]pdfOutlineItem{{title: book.Title, page: 1}}, starts...)
+	}
+
+	for i, s := range starts {
+		end := len(pages) + 1
+		if i+1 < len(starts) {
+			end = starts[i+1].page
+		}
+		book.Chapters = append(book.Chapters, model.Chapter{
+			ID:       i,
+			Title:    s.title,
+			Level:    s.level,
+			FilePath: filePath,
+			Offset:   int64(s.page),
+			Length:   int64(max(end-s.page, 1)),
+		})
+	}
+	return book, nil
+}
+
+func (p *PDFParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (content string, err error) {
+	if chapterID < 0 || chapterID >= len(book.Chapters) {
+		return "", fmt.Errorf("chapter %d out of range", chapterID)
+	}
+	ch := book.Chapters[chapterID]
+
+	f, r, err := pdf.Open(ch.FilePath)
+	if err != nil {
+		return "", fmt.Errorf("open pdf: %w", err)
+	}
+	defer f.Close()
+	defer func() {
+		if x := recover(); x != nil {
+			content, err = "", fmt.Errorf("read pdf page: %v", x)
+		}
+	}()
+
+	pages := pdfPages(r)
+	var buf strings.Builder
+	buf.WriteString(`<div class="pdf-chapter">`)
+	for n := max(int(ch.Offset), 1); n < int(ch.Offset+ch.Length) && n <= len(pages); n++ {
+		fmt.Fprintf(&buf, `<div class="pdf-page" id="page-%d">`, n)
+		buf.WriteString(pdfPageHTML(pages[n-1]))
+		buf.WriteString(`</div>`)
+	}
+	buf.WriteString(`</div>`)
+	return buf.String(), nil
+}
+
+// pdfPages 一次遍历页面树得到按顺序排列的所有页面
+func pdfPages(r *pdf.Reader) []pdf.Page {
+	var pages []pdf.Page
+	var walk func(node pdf.Value, depth int)
+	walk = func(node pdf.Value, depth int) {
+		// 防止循环引用
+		if depth > 64 {
+			return
+		}
+		kids := node.Key("Kids")
+		if node.Key("Type").Name() == "Page" || (kids.Kind() != pdf.Array && node.Key("Contents").Kind() != pdf.Null) {
+			pages = append(pages, pdf.Page{V: node})
+			return
+		}
+		for i := 0; i < kids.Len(); i++ {
+			walk(kids.Index(i), depth+1)
+		}
+	}
+	walk(r.Trailer().Key("Root").Key("Pages"), 0)
+	return pages
+}
+
+// collectPDFOutline 深度优先收集大纲条目及其目标页码，pages 为总页数
+func collectPDFOutline(r *pdf.Reader, node pdf.Value, level int, pageNum map[string]int, pages int, items *[]pdfOutlineItem, depth int) {
+	if depth > 32 {
+		return
+	}
+	// 兄弟链表可能很长，限制数量防止循环
+	n := 0
+	for item := node.Key("First"); item.Kind() == pdf.Dict && n < 10000; item = item.Key("Next") {
+		n++
+		title := strings.Join(strings.Fields(item.Key("Title").Text()), " ")
+		if page, ok := pdfDestPage(r, item, pageNum, pages); ok && title != "" {
+			*items = append(*items, pdfOutlineItem{title: title, page: page, level: level})
+		}
+		collectPDFOutline(r, item, level+1, pageNum, pages, items, depth+1)
+	}
+}
+
+// pdfDestPage 解析大纲条目的 /Dest 或 /A GoTo 动作，返回目标页码；页码不在 1..pages 内时返回 false
+func pdfDestPage(r *pdf.Reader, item pdf.Value, pageNum map[string]int, pages int) (int, bool) {
+	dest := item.Key("Dest")
+	if dest.IsNull() {
+		if a := item.Key("A"); a.Key("S").Name() == "GoTo" {
+			dest = a.Key("D")
+		}
+	}
+	// 命名目标：/Root/Dests 字典或 /Root/Names/Dests 名称树
+	if dest.Kind() == pdf.Name || dest.Kind() == pdf.String {
+		key := dest.Name()
+		if dest.Kind() == pdf.String {
+			key = dest.RawString()
+		}
+		root := r.Trailer().Key("Root")
+		named := root.Key("Dests").Key(key)
+		if named.IsNull() {
+			named = pdfNameTreeLookup(root.Key("Names").Key("Dests"), key, 0)
+		}
+		dest = named
+	}
+	if dest.Kind() == pdf.Dict {
+		dest = dest.Key("D")
+	}
+	if dest.Kind() != pdf.Array || dest.Len() == 0 {
+		return 0, false
+	}
+	target := dest.Index(0)
+	// 少数文件用页码（从 0 开始）代替页面引用
+	if target.Kind() == pdf.Integer {
+		n := target.Int64() + 1
+		return int(n), n >= 1 && n <= int64(pages)
+	}
+	page, ok := pageNum[target.String()]
+	return page, ok
+}
+
+// pdfNameTreeLookup 在名称树中查找 key
+func pdfNameTreeLookup(node pdf.Value, key string, depth int) pdf.Value {
+	if node.IsNull() || depth > 32 {
+		return pdf.Value{}
+	}
+	if names := node.Key("Names"); names.Kind() == pdf.Array {
+		for i := 0; i+1 < names.Len(); i += 2 {
+			if names.Index(i).RawString() == key {
+				return names.Index(i + 1)
+			}
+		}
+	}
+	kids := node.Key("Kids")
+	for i := 0; i < kids.Len(); i++ {
+		kid := kids.Index(i)
+		if limits := kid.Key("Limits"); limits.Len() == 2 {
+			if key < limits.Index(0).RawString() || key > limits.Index(1).RawString() {
+				continue
+			}
+		}
+		if v := pdfNameTreeLookup(kid, key, depth+1); !v.IsNull() {
+			return v
+		}
+	}
+	return pdf.Value{}
+}
+
+// pdfXMPMeta 从 XMP 元数据流中读取 dc:title 和 dc:creator
+func pdfXMPMeta(stream pdf.Value) (title, author string) {
+	if stream.Kind() != pdf.Stream {
+		return "", ""
+	}
+	rc := stream.Reader()
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, 4<<20))
+	if err != nil || len(data) == 0 {
+		return "", ""
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	var field string // 当前所在的 dc 字段
+	var inLi bool
+	var creators []string
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "title" || t.Name.Local == "creator" {
+				field = t.Name.Local
+			} else if t.Name.Local == "li" {
+				inLi = true
+			}
+		case xml.EndElement:
+			if t.Name.Local == field {
+				field = ""
+			} else if t.Name.Local == "li" {
+				inLi = false
+			}
+		case xml.CharData:
+			s := strings.TrimSpace(string(t))
+			if s == "" || !inLi {
+				continue
+			}
+			switch field {
+			case "title":
+				if title == "" {
+					title = s
+				}
+			case "creator":
+				creators = append(creators, s)
+			}
+		}
+	}
+	return title, strings.Join(creators, ", ")
+}
+
+// pdfLine 由同一基线上的字形拼成的一行
+type pdfLine struct {
+	text  string
+	x, y  float64
+	right float64
+	size  float64
+}
+
+// pdfPageHTML 提取一页的文字并按行距、缩进、字号重排为段落
+func pdfPageHTML(page pdf.Page) string {
+	lines := pdfPageLines(page.Content().Text)
+	if len(lines) == 0 {
+		return ""
+	}
+
+	// 正文字号取中位数，行距取下四分位数，排除标题、段间距等少数行的干扰
+	var sizes, gaps []float64
+	minX, maxRight := math.Inf(1), 0.0
+	for i, l := range lines {
+		sizes = append(sizes, l.size)
+		minX = math.Min(minX, l.x)
+		maxRight = math.Max(maxRight, l.right)
+		if i > 0 {
+			if g := lines[i-1].y - l.y; g > 0 {
+				gaps = append(gaps, g)
+			}
+		}
+	}
+	body := quantile(sizes, 0.5)
+	gap := quantile(gaps, 0.25)
+	if gap == 0 {
+		gap = body * 1.2
+	}
+
+	var buf strings.Builder
+	var para []string
+	heading := false
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		text := renderInline(joinWrapped(para))
+		if heading {
+			buf.WriteString(`<h3>` + text + `</h3>`)
+		} else {
+			buf.WriteString(`<p>` + text + `</p>`)
+		}
+		para = para[:0]
+	}
+	for i, l := range lines {
+		isHeading := l.size >= body*1.25
+		if i > 0 {
+			prev := lines[i-1]
+			newPara := isHeading != heading ||
+				prev.y-l.y > gap*1.5 || prev.y < l.y || // 段间距大，或换栏
+				prev.right < maxRight-body*3 || // 上一行明显没写满
+				(!isHeading && l.x > minX+body*0.8 && l.x > prev.x+body*0.8) // 首行缩进
+			if newPara {
+				flush()
+			}
+		}
+		heading = isHeading
+		para = append(para, l.text)
+	}
+	flush()
+	return buf.String()
+}
+
+// pdfPageLines 按内容流顺序把字形归并为行，行内按横坐标排序并补上词间空格
+func pdfPageLines(texts []pdf.Text) []pdfLine {
+	var lines []pdfLine
+	var cur []pdf.Text
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		sort.SliceStable(cur, func(i, j int) bool { return cur[i].X < cur[j].X })
+		var sb strings.Builder
+		l := pdfLine{x: cur[0].X, y: cur[0].Y}
+		prevEnd := math.Inf(-1)
+		for _, t := range cur {
+			size := math.Max(t.FontSize, 1)
+			l.size = math.Max(l.size, size)
+			if sb.Len() > 0 && t.X-prevEnd > size*0.15 {
+				last, _ := utf8.DecodeLastRuneInString(sb.String())
+				first, _ := utf8.DecodeRuneInString(t.S)
+				if last != ' ' && first != ' ' && !(isCJKNoSpace(last) && isCJKNoSpace(first)) {
+					sb.WriteByte(' ')
+				}
+			}
+			sb.WriteString(t.S)
+			prevEnd = math.Max(prevEnd, t.X+t.W)
+		}
+		l.right = prevEnd
+		l.text = strings.TrimSpace(sb.String())
+		if l.text != "" {
+			lines = append(lines, l)
+		}
+		cur = cur[:0]
+	}
+	for _, t := range texts {
+		if t.S == "" {
+			continue
+		}
+		if len(cur) > 0 && math.Abs(t.Y-cur[0].Y) > math.Max(t.FontSize, 1)*0.5 {
+			flush()
+		}
+		cur = append(cur, t)
+	}
+	flush()
+	return lines
+}
+
+// quantile 返回 q 分位数，空切片返回 0
+func quantile(v []float64, q float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), v...)
+	sort.Float64s(s)
+	return s[int(float64(len(s)-1)*q)]
+}
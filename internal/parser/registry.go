@@ -0,0 +1,276 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Format 一种电子书格式的注册信息，每个解析器文件在 init 中调用 Register 注册自己
+type Format struct {
+	Name       string   `json:"name"`                // 格式名，通常即 Book.Format
+	Aliases    []string `json:"-"`                   // 解析器产出的其他 Book.Format 取值，如合集 "collection"
+	Extensions []string `json:"extensions"`          // 小写扩展名，含点，可以是双扩展名如 ".fb2.zip"
+	MIMETypes  []string `json:"mimeTypes,omitempty"` // 对应的 Content-Type
+	// Capabilities 格式支持的特性，供客户端决定展示哪些功能
+	Capabilities Capabilities `json:"capabilities"`
+	// Sniff 按文件内容识别格式，返回识别出的扩展名（空为 Extensions[0]）和可信程度；nil 表示无法按内容识别
+	Sniff func(s *Sample) (string, SniffLevel) `json:"-"`
+	// Fallback 同一可信程度有多个格式匹配，且文件名与 Content-Type 都无法区分时优先选择
+	Fallback bool `json:"-"`
+	// New 创建解析器实例
+	New func() Parser `json:"-"`
+}
+
+// Capabilities 格式能力标记
+type Capabilities struct {
+	Resources  bool `json:"resources"`  // 章节引用图片等资源（/api/book/resource/）
+	Cover      bool `json:"cover"`      // 能提取封面
+	TOCTree    bool `json:"tocTree"`    // 目录有层级（Chapter.Level）
+	SearchText bool `json:"searchText"` // 章节含可检索的文字（漫画等纯图片格式没有）
+}
+
+// SniffLevel 内容识别结果的可信程度
+type SniffLevel int
+
+const (
+	SniffNone      SniffLevel = iota
+	SniffText                 // 任意文本都匹配，只能靠文件名或 Content-Type 区分
+	SniffContainer            // 匹配容器（zip / rar 等），同一容器可能对应多种格式
+	SniffExact                // 确定是该格式
+)
+
+// Sample 内容识别时可用的文件信息
+type Sample struct {
+	Head     []byte          // 文件开头（至多 1 KB）
+	ZipFiles map[string]bool // zip 包内的文件名，非 zip 时为 nil
+	Mimetype string          // zip 包内 mimetype 条目的内容
+}
+
+var (
+	registryMu sync.RWMutex
+	formats    []*Format
+)
+
+// Register 注册一种格式，通常在解析器文件的 init 中调用
+func Register(f Format) {
+	if f.Name == "" || f.New == nil {
+		panic("parser: Register requires Name and New")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	formats = append(formats, &f)
+}
+
+// Formats 返回已注册的格式，按名称排序
+func Formats() []Format {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	out := make([]Format, 0, len(formats))
+	for _, f := range formats {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// GetParser 根据文件扩展名或格式名返回对应的解析器
+func GetParser(nameOrFormat string) (Parser, error) {
+	f, _ := lookup(nameOrFormat)
+	if f == nil {
+		ext := filepath.Ext(strings.ToLower(nameOrFormat))
+		if ext == "" {
+			ext = "." + strings.ToLower(nameOrFormat)
+		}
+		return nil, fmt.Errorf("unsupported format: %s", ext)
+	}
+	return f.New(), nil
+}
+
+// lookup 按格式名或文件名查找格式，文件名取最长匹配的扩展名（book.fb2.zip 优先于 .zip），
+// 返回格式和匹配到的扩展名
+func lookup(nameOrFormat string) (*Format, string) {
+	s := strings.ToLower(nameOrFormat)
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var found *Format
+	best := ""
+	for _, f := range formats {
+		if f.Name == s {
+			return f, f.Extensions[0]
+		}
+		for _, a := range f.Aliases {
+			if a == s {
+				return f, f.Extensions[0]
+			}
+		}
+		for _, ext := range f.Extensions {
+			// 也支持不带点的扩展名作格式名，如 "htmlz"
+			if (strings.HasSuffix(s, ext) || "."+s == ext) && len(ext) > len(best) {
+				found, best = f, ext
+			}
+		}
+	}
+	return found, best
+}
+
+// byMIME 按 Content-Type 查找格式
+func byMIME(contentType string) (*Format, string) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ""
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, f := range formats {
+		for _, m := range f.MIMETypes {
+			if m == mt {
+				return f, f.Extensions[0]
+			}
+		}
+	}
+	return nil, ""
+}
+
+// Detect 识别下载文件的格式，返回应使用的扩展名，识别不出时返回空串。
+// 优先按文件内容识别；内容只能确定容器或文本时，按文件名、Content-Type 在匹配的格式中细分；
+// 内容无法识别时依次使用文件名和 Content-Type
+func Detect(filePath, name, contentType string) string {
+	nameFmt, _ := lookup(fileExt(name))
+	nameExt := fileExt(name)
+	ctFmt, ctExt := byMIME(contentType)
+
+	s, err := readSample(filePath)
+	if err != nil {
+		return ""
+	}
+	type candidate struct {
+		f   *Format
+		ext string
+	}
+	var cands []candidate
+	best := SniffNone
+	registryMu.RLock()
+	for _, f := range formats {
+		if f.Sniff == nil {
+			continue
+		}
+		ext, level := f.Sniff(s)
+		if level == SniffNone || level < best {
+			continue
+		}
+		if level > best {
+			best, cands = level, nil
+		}
+		if ext == "" {
+			ext = f.Extensions[0]
+		}
+		cands = append(cands, candidate{f, ext})
+	}
+	registryMu.RUnlock()
+
+	if len(cands) == 0 {
+		if nameFmt != nil {
+			return nameExt
+		}
+		return ctExt
+	}
+	for _, c := range cands {
+		if c.f == nameFmt {
+			return nameExt
+		}
+	}
+	for _, c := range cands {
+		if c.f == ctFmt {
+			return ctExt
+		}
+	}
+	for _, c := range cands {
+		if c.f.Fallback {
+			return c.ext
+		}
+	}
+	return cands[0].ext
+}
+
+// fileExt 从文件名提取小写扩展名，保留 .fb2.zip / .txt.gz 这样的双扩展名
+func fileExt(name string) string {
+	name = strings.ToLower(filepath.Base(name))
+	ext := filepath.Ext(name)
+	if ext == "" || ext == name {
+		return ""
+	}
+	// 单文件 gzip 据内部扩展名得知格式
+	if inner := filepath.Ext(strings.TrimSuffix(name, ext)); inner == ".fb2" || inner != "" && ext == ".gz" {
+		ext = inner + ext
+	}
+	return ext
+}
+
+// readSample 读取文件开头，zip 文件另外读取包内文件列表和 mimetype 条目
+func readSample(filePath string) (*Sample, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(f, head)
+	f.Close()
+	s := &Sample{Head: head[:n]}
+	if !isZip(s.Head) {
+		return s, nil
+	}
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return s, nil
+	}
+	defer zr.Close()
+	s.ZipFiles = make(map[string]bool, len(zr.File))
+	for _, zf := range zr.File {
+		s.ZipFiles[zf.Name] = true
+		if zf.Name != "mimetype" {
+			continue
+		}
+		if rc, err := zf.Open(); err == nil {
+			mt, _ := io.ReadAll(io.LimitReader(rc, 128))
+			rc.Close()
+			s.Mimetype = strings.TrimSpace(string(mt))
+		}
+	}
+	return s, nil
+}
+
+func isZip(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06"))
+}
+
+// IsText 文件开头是否像文本：没有 NUL 字节，或带 UTF-16 BOM
+func (s *Sample) IsText() bool {
+	if len(s.Head) == 0 {
+		return false
+	}
+	if bytes.HasPrefix(s.Head, []byte("\xff\xfe")) || bytes.HasPrefix(s.Head, []byte("\xfe\xff")) {
+		return true
+	}
+	return bytes.IndexByte(s.Head, 0) < 0
+}
+
+// Markup 返回去掉 UTF-8 BOM 和前导空白后转为小写的文件开头，用于识别标记语言
+func (s *Sample) Markup() []byte {
+	return bytes.ToLower(bytes.TrimLeft(bytes.TrimPrefix(s.Head, []byte("\xef\xbb\xbf")), " \t\r\n"))
+}
+
+// sniffText 任意文本都匹配的识别函数
+func sniffText(s *Sample) (string, SniffLevel) {
+	if s.IsText() {
+		return "", SniffText
+	}
+	return "", SniffNone
+}
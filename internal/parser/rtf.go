@@ -0,0 +1,641 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"ebook-reader/internal/model"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// RTFParser RTF 文档解析器：按标题样式（或 \outlinelevel）切分章节，
+// 没有标题样式时按章节标题行切分，再退回按大小分段
+type RTFParser struct{}
+
+func init() {
+	Register(Format{
+		Name:         "rtf",
+		Extensions:   []string{".rtf"},
+		MIMETypes:    []string{"application/rtf", "text/rtf"},
+		Capabilities: Capabilities{Resources: true, TOCTree: true, SearchText: true},
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if bytes.HasPrefix(s.Head, []byte(`{\rtf`)) {
+				return "", SniffExact
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &RTFParser{} },
+	})
+}
+
+// rtfSectionSize 没有任何标题时按该大小（HTML 字节数）分段
+const rtfSectionSize = 32 * 1024
+
+// 跳过整个组的目标（页眉页脚、表格定义、对象等）
+var rtfSkipDests = map[string]bool{
+	"colortbl": true, "header": true, "headerl": true, "headerr": true, "headerf": true,
+	"footer": true, "footerl": true, "footerr": true, "footerf": true, "footnote": true,
+	"object": true, "fldinst": true, "datafield": true, "themedata": true, "colorschememapping": true,
+	"latentstyles": true, "listtable": true, "listoverridetable": true, "rsidtbl": true,
+	"generator": true, "xmlnstbl": true, "mmathPr": true, "nonshppict": true, "filetbl": true,
+	"revtbl": true, "pn": true, "txe": true, "xe": true, "tc": true, "operator": true,
+	"company": true, "comment": true, "doccomm": true, "keywords": true, "subject": true,
+}
+
+// 以 \* 开头但需要处理的目标
+var rtfKeepStarDests = map[string]bool{"shppict": true}
+
+// 简单替换为字符的控制字
+var rtfSymbols = map[string]string{
+	"emdash": "—", "endash": "–", "bullet": "•", "lquote": "‘", "rquote": "’",
+	"ldblquote": "“", "rdblquote": "”", "emspace": " ", "enspace": " ",
+	"tab": " ", "cell": " ",
+}
+
+// 样式名中的标题级别：heading 1 / 标题 1
+var rtfHeadingStyle = regexp.MustCompile(`(?i)^(?:heading|标题|標題)\s*(\d)$`)
+
+// rtfState 随 { } 保存和恢复的状态
+type rtfState struct {
+	dest    string // 当前目标：空为正文，fonttbl / stylesheet / info / title / author / pict
+	skip    bool
+	bold    bool
+	italic  bool
+	uc      int // \uN 之后跳过的替代字符数
+	font    int
+	style   int
+	outline int // 段落大纲级别（0 起），-1 表示无
+}
+
+// rtfPara 解析出的段落
+type rtfPara struct {
+	level int // 标题级别（1 起），0 为正文
+	html  string
+	text  string
+}
+
+type rtfConverter struct {
+	data  []byte
+	pos   int
+	st    rtfState
+	stack []rtfState
+
+	ansiCP    int
+	fonts     map[int]int // 字体号 -> 代码页
+	styles    map[int]int // 样式号 -> 标题级别
+	curFont   int
+	curStyle  int
+	styleName strings.Builder
+
+	pending  []byte // 等待按当前代码页解码的字节
+	skipN    int    // \uN 之后剩余要跳过的字符数
+	surr     rune   // 尚未配对的 UTF-16 高位代理
+	star     bool   // 上一个控制符为 \*
+	run      strings.Builder
+	runB     bool
+	runI     bool
+	para     strings.Builder
+	paraText strings.Builder
+	paras    []rtfPara
+
+	title, author strings.Builder
+
+	pictType string
+	pictData []byte
+	imageDir string
+	images   int
+}
+
+func (p *RTFParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \r\n\t"), []byte(`{\rtf`)) {
+		return nil, errors.New("not an rtf file")
+	}
+	c := &rtfConverter{
+		data:     data,
+		st:       rtfState{uc: 1, outline: -1},
+		ansiCP:   1252,
+		fonts:    make(map[int]int),
+		styles:   make(map[int]int),
+		imageDir: filepath.Join(cachePath, "images"),
+	}
+	if err := c.convert(); err != nil {
+		return nil, fmt.Errorf("parse rtf: %w", err)
+	}
+
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "rtf",
+		CachePath: cachePath,
+	}
+	if t := strings.TrimSpace(c.title.String()); t != "" {
+		book.Title = t
+	}
+	if a := strings.TrimSpace(c.author.String()); a != "" {
+		book.Author = a
+	}
+
+	w, err := newDocWriter(book, "rtf-chapter")
+	if err != nil {
+		return nil, err
+	}
+	hasHeading := false
+	for _, para := range c.paras {
+		if para.level > 0 {
+			hasHeading = true
+			break
+		}
+	}
+	// 没有标题样式时用 TXT 的章节标题规则
+	if !hasHeading {
+		for i, para := range c.paras {
+			if len(para.text) <= maxTitleLineLen && chapterPattern.MatchString(para.text) {
+				c.paras[i].level = 1
+				hasHeading = true
+			}
+		}
+	}
+	section := 0
+	for _, para := range c.paras {
+		if para.level > 0 {
+			if err := w.heading(para.level, para.text, para.html); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !hasHeading && (section == 0 || w.buf.Len() >= rtfSectionSize) {
+			section++
+			if err := w.section(1, fmt.Sprintf("Section %d", section)); err != nil {
+				return nil, err
+			}
+		}
+		w.write("<p>" + para.html + "</p>")
+	}
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	if len(book.Chapters) == 0 {
+		return nil, errors.New("rtf: document is empty")
+	}
+	return book, nil
+}
+
+func (p *RTFParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	return readChapterFile(book, chapterID, fileURL)
+}
+
+func (c *rtfConverter) convert() error {
+	for c.pos < len(c.data) {
+		b := c.data[c.pos]
+		switch b {
+		case '{':
+			c.pos++
+			c.star = false
+			c.flushBytes()
+			c.stack = append(c.stack, c.st)
+			c.skipN = 0
+		case '}':
+			c.pos++
+			c.star = false
+			c.flushBytes()
+			if len(c.stack) == 0 {
+				continue
+			}
+			closed := c.st
+			c.st = c.stack[len(c.stack)-1]
+			c.stack = c.stack[:len(c.stack)-1]
+			c.skipN = 0
+			if closed.dest == "pict" && c.st.dest != "pict" && !closed.skip {
+				c.writePict()
+			}
+		case '\\':
+			if err := c.control(); err != nil {
+				return err
+			}
+		case '\r', '\n':
+			c.pos++
+		default:
+			c.pos++
+			if c.st.skip {
+				continue
+			}
+			if c.skipN > 0 {
+				c.skipN--
+				continue
+			}
+			if c.st.dest == "pict" {
+				c.pictData = append(c.pictData, b)
+				continue
+			}
+			c.pending = append(c.pending, b)
+		}
+	}
+	c.endParagraph()
+	return nil
+}
+
+// control 读取一个控制字或控制符号
+func (c *rtfConverter) control() error {
+	c.pos++ // '\'
+	if c.pos >= len(c.data) {
+		return nil
+	}
+	ch := c.data[c.pos]
+	if !isASCIILetter(ch) {
+		c.pos++
+		c.symbol(ch)
+		return nil
+	}
+	start := c.pos
+	for c.pos < len(c.data) && isASCIILetter(c.data[c.pos]) {
+		c.pos++
+	}
+	word := string(c.data[start:c.pos])
+	hasParam := false
+	param := 0
+	pstart := c.pos
+	if c.pos < len(c.data) && (c.data[c.pos] == '-' || isDigit(c.data[c.pos])) {
+		c.pos++
+		for c.pos < len(c.data) && isDigit(c.data[c.pos]) {
+			c.pos++
+		}
+		param, _ = strconv.Atoi(string(c.data[pstart:c.pos]))
+		hasParam = true
+	}
+	// 控制字后的一个空格是分隔符
+	if c.pos < len(c.data) && c.data[c.pos] == ' ' {
+		c.pos++
+	}
+
+	// \binN 之后是 N 字节原始数据，无论是否跳过都要整体越过
+	if word == "bin" {
+		end := min(c.pos+max(param, 0), len(c.data))
+		if c.st.dest == "pict" && !c.st.skip {
+			c.pictData = append(c.pictData, []byte(hex.EncodeToString(c.data[c.pos:end]))...)
+		}
+		c.pos = end
+		return nil
+	}
+	if c.st.skip {
+		return nil
+	}
+	star := c.star
+	c.star = false
+	if star && !rtfKeepStarDests[word] {
+		c.st.skip = true
+		return nil
+	}
+	if rtfSkipDests[word] {
+		c.st.skip = true
+		return nil
+	}
+	if c.skipN > 0 {
+		c.skipN--
+		return nil
+	}
+	c.word(word, param, hasParam)
+	return nil
+}
+
+// symbol 处理控制符号 \'hh \~ \* 等
+func (c *rtfConverter) symbol(ch byte) {
+	if ch == '*' {
+		c.star = true
+		return
+	}
+	if c.st.skip {
+		return
+	}
+	if ch == '\'' {
+		if c.pos+2 > len(c.data) {
+			c.pos = len(c.data)
+			return
+		}
+		v, err := strconv.ParseUint(string(c.data[c.pos:c.pos+2]), 16, 8)
+		c.pos += 2
+		if c.skipN > 0 {
+			c.skipN--
+			return
+		}
+		if err == nil {
+			c.pending = append(c.pending, byte(v))
+		}
+		return
+	}
+	if c.skipN > 0 {
+		c.skipN--
+		return
+	}
+	switch ch {
+	case '\\', '{', '}':
+		c.pending = append(c.pending, ch)
+	case '~':
+		c.text(" ")
+	case '_':
+		c.text("-")
+	case '\r', '\n':
+		// 转义的换行等同于 \par
+		c.endParagraph()
+	}
+}
+
+func (c *rtfConverter) word(word string, param int, hasParam bool) {
+	on := !hasParam || param != 0
+	switch word {
+	case "ansicpg":
+		c.flushBytes()
+		c.ansiCP = param
+	case "f":
+		if c.st.dest == "fonttbl" {
+			c.curFont = param
+		} else {
+			c.flushBytes()
+			c.st.font = param
+		}
+	case "fcharset":
+		if c.st.dest == "fonttbl" {
+			c.fonts[c.curFont] = rtfCharsetCodepage(param)
+		}
+	case "cpg":
+		if c.st.dest == "fonttbl" {
+			c.fonts[c.curFont] = param
+		}
+	case "uc":
+		c.st.uc = max(param, 0)
+	case "u":
+		c.flushBytes()
+		r := rune(param)
+		if r < 0 {
+			r += 65536
+		}
+		switch {
+		case utf16.IsSurrogate(r) && r < 0xdc00:
+			c.surr = r
+		case utf16.IsSurrogate(r) && c.surr != 0:
+			c.text(string(utf16.DecodeRune(c.surr, r)))
+			c.surr = 0
+		default:
+			c.text(string(r))
+		}
+		c.skipN = c.st.uc
+	case "b":
+		c.flushBytes()
+		c.st.bold = on
+	case "i":
+		c.flushBytes()
+		c.st.italic = on
+	case "plain":
+		c.flushBytes()
+		c.st.bold, c.st.italic = false, false
+	case "pard":
+		c.st.style, c.st.outline = 0, -1
+	case "s":
+		if c.st.dest == "stylesheet" {
+			c.curStyle = param
+		} else {
+			c.st.style = param
+		}
+	case "outlinelevel":
+		if c.st.dest == "stylesheet" {
+			c.styles[c.curStyle] = param + 1
+		} else {
+			c.st.outline = param
+		}
+	case "par", "sect", "row":
+		c.endParagraph()
+	case "line":
+		c.flushBytes()
+		c.flushRun()
+		c.para.WriteString("<br>")
+		c.paraText.WriteByte(' ')
+	case "fonttbl", "stylesheet", "info", "title", "author":
+		c.flushBytes()
+		c.st.dest = word
+		if word == "stylesheet" {
+			c.curStyle = 0
+			c.styleName.Reset()
+		}
+	case "pict":
+		c.flushBytes()
+		c.st.dest = "pict"
+		c.pictType, c.pictData = "", c.pictData[:0]
+	case "pngblip":
+		c.pictType = ".png"
+	case "jpegblip":
+		c.pictType = ".jpg"
+	default:
+		if s, ok := rtfSymbols[word]; ok {
+			c.text(s)
+		}
+	}
+}
+
+// flushBytes 按当前字体（或文档）代码页解码累积的字节
+func (c *rtfConverter) flushBytes() {
+	if len(c.pending) == 0 {
+		return
+	}
+	data := c.pending
+	c.pending = c.pending[:0]
+	cp := c.ansiCP
+	if c.st.dest == "" {
+		if fcp, ok := c.fonts[c.st.font]; ok && fcp != 0 {
+			cp = fcp
+		}
+	}
+	s := string(data)
+	if enc := rtfCodepage(cp); enc != nil {
+		if decoded, err := enc.NewDecoder().Bytes(data); err == nil {
+			s = string(decoded)
+		}
+	}
+	c.text(s)
+}
+
+// text 把解码后的文本写入当前目标
+func (c *rtfConverter) text(s string) {
+	switch c.st.dest {
+	case "":
+		if c.runB != c.st.bold || c.runI != c.st.italic {
+			c.flushRun()
+			c.runB, c.runI = c.st.bold, c.st.italic
+		}
+		c.run.WriteString(htmlEscape(s))
+		c.paraText.WriteString(s)
+	case "title":
+		c.title.WriteString(s)
+	case "author":
+		c.author.WriteString(s)
+	case "stylesheet":
+		// 样式名以分号结束
+		for {
+			name, rest, ok := strings.Cut(s, ";")
+			c.styleName.WriteString(name)
+			if !ok {
+				break
+			}
+			if m := rtfHeadingStyle.FindStringSubmatch(strings.TrimSpace(c.styleName.String())); m != nil {
+				c.styles[c.curStyle], _ = strconv.Atoi(m[1])
+			} else if strings.EqualFold(strings.TrimSpace(c.styleName.String()), "title") {
+				c.styles[c.curStyle] = 1
+			}
+			c.styleName.Reset()
+			c.curStyle = 0
+			s = rest
+		}
+	}
+}
+
+func (c *rtfConverter) flushRun() {
+	if c.run.Len() == 0 {
+		return
+	}
+	c.para.WriteString(wrapFormat(c.run.String(), c.runB, c.runI))
+	c.run.Reset()
+}
+
+func (c *rtfConverter) endParagraph() {
+	if c.st.dest != "" {
+		return
+	}
+	c.flushBytes()
+	c.flushRun()
+	html := strings.TrimSpace(c.para.String())
+	text := strings.Join(strings.Fields(c.paraText.String()), " ")
+	c.para.Reset()
+	c.paraText.Reset()
+	if html == "" {
+		return
+	}
+	level := c.styles[c.st.style]
+	if c.st.outline >= 0 && c.st.outline < 9 {
+		level = c.st.outline + 1
+	}
+	// 只有图片的段落不作为标题
+	if text == "" {
+		level = 0
+	}
+	c.paras = append(c.paras, rtfPara{level: level, html: html, text: text})
+}
+
+// writePict 保存 PNG / JPEG 图片（十六进制或 \bin 数据），其他格式（WMF / EMF 等）丢弃
+func (c *rtfConverter) writePict() {
+	if c.pictType == "" {
+		return
+	}
+	hexData := bytes.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, c.pictData)
+	img := make([]byte, hex.DecodedLen(len(hexData)))
+	n, err := hex.Decode(img, hexData[:len(hexData)&^1])
+	if err != nil || n == 0 {
+		return
+	}
+	if err := os.MkdirAll(c.imageDir, 0755); err != nil {
+		return
+	}
+	c.images++
+	file := fmt.Sprintf("%04d%s", c.images, c.pictType)
+	if err := os.WriteFile(filepath.Join(c.imageDir, file), img[:n], 0644); err != nil {
+		return
+	}
+	c.flushRun()
+	c.para.WriteString(`<img src="../images/` + file + `" alt="">`)
+}
+
+func isASCIILetter(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// rtfCharsetCodepage 字体 \fcharset 对应的代码页，0 表示沿用文档的 \ansicpg
+func rtfCharsetCodepage(cs int) int {
+	switch cs {
+	case 128:
+		return 932
+	case 129:
+		return 949
+	case 134:
+		return 936
+	case 136:
+		return 950
+	case 161:
+		return 1253
+	case 162:
+		return 1254
+	case 177:
+		return 1255
+	case 178:
+		return 1256
+	case 186:
+		return 1257
+	case 204:
+		return 1251
+	case 222:
+		return 874
+	case 238:
+		return 1250
+	}
+	return 0
+}
+
+// rtfCodepage 代码页对应的解码器，UTF-8 或未知代码页返回 nil
+func rtfCodepage(cp int) encoding.Encoding {
+	switch cp {
+	case 936, 54936:
+		return simplifiedchinese.GB18030
+	case 950:
+		return traditionalchinese.Big5
+	case 932:
+		return japanese.ShiftJIS
+	case 949:
+		return korean.EUCKR
+	case 437:
+		return charmap.CodePage437
+	case 850:
+		return charmap.CodePage850
+	case 874:
+		return charmap.Windows874
+	case 10000:
+		return charmap.Macintosh
+	case 1250:
+		return charmap.Windows1250
+	case 1251:
+		return charmap.Windows1251
+	case 1252:
+		return charmap.Windows1252
+	case 1253:
+		return charmap.Windows1253
+	case 1254:
+		return charmap.Windows1254
+	case 1255:
+		return charmap.Windows1255
+	case 1256:
+		return charmap.Windows1256
+	case 1257:
+		return charmap.Windows1257
+	case 1258:
+		return charmap.Windows1258
+	}
+	return nil
+}
@@ -3,39 +3,126 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"ebook-reader/internal/job"
 	"ebook-reader/internal/model"
+	"ebook-reader/internal/purify"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"unicode/utf8"
 
-	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 )
 
 // TXTParser TXT 格式解析器
-type TXTParser struct{}
+type TXTParser struct {
+	// Encoding 强制指定源文件编码（如 "big5"），为空时自动检测
+	Encoding string
+	// Purifier 读取章节时的内容净化规则，为 nil 时不净化
+	Purifier *purify.Purifier
+}
+
+func init() {
+	Register(Format{
+		Name:         "txt",
+		Extensions:   []string{".txt"},
+		MIMETypes:    []string{"text/plain"},
+		Capabilities: Capabilities{SearchText: true},
+		Sniff:        sniffText,
+		Fallback:     true,
+		New:          func() Parser { return &TXTParser{} },
+	})
+}
 
-// 章节标题匹配正则：第X章、第X节、Chapter X 等
+// 章节标题匹配正则：第X章、第X节、Chapter X 等（逐行匹配，行尾换行符已去除）
 var chapterPattern = regexp.MustCompile(
-	`(?m)^\s*(第[零一二三四五六七八九十百千万\d]+[章节回卷集部篇]|Chapter\s+\d+|CHAPTER\s+\d+)(.*)$`,
+	`^\s*(第[零一二三四五六七八九十百千万\d]+[章节回卷集部篇]|Chapter\s+\d+|CHAPTER\s+\d+)(.*)$`,
 )
 
-func (p *TXTParser) Parse(filePath string, cachePath string) (*model.Book, error) {
-	raw, err := os.ReadFile(filePath)
+const (
+	// txtReadBufSize 读缓冲大小，同时也是编码检测的采样长度
+	txtReadBufSize = 64 * 1024
+	// txtSectionSize 无章节标题时的分段大小
+	txtSectionSize = 8192
+	// maxTitleLineLen 超过该长度的行不会被当作章节标题
+	maxTitleLineLen = 256
+)
+
+// Parse 流式解析 TXT：边解码边写入 UTF-8 缓存文件，同时逐行查找章节边界，
+// 内存占用与文件大小无关
+func (p *TXTParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	f, err := os.Open(filePath)
 	if err != nil {
+		return nil, fmt.Errorf("open txt: %w", err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, txtReadBufSize)
+	sample, err := br.Peek(txtReadBufSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
 		return nil, fmt.Errorf("read txt: %w", err)
 	}
 
-	// 编码检测与转换
-	content := detectAndConvert(raw)
+	cs := detectCharset(sample)
+	if p.Encoding == "" && cs.Encoding == nil && len(sample) == txtReadBufSize && firstNonASCII(sample) < 0 {
+		// 开头全是 ASCII（如英文版权声明）时无从判断编码，改用第一个非 ASCII 字节处的采样
+		more, err := sampleAfterASCII(ctx, f, int64(len(sample)))
+		if err != nil {
+			return nil, fmt.Errorf("read txt: %w", err)
+		}
+		if more != nil {
+			cs = detectCharset(more)
+		}
+	}
+	if p.Encoding != "" {
+		bomLen := cs.BOMLen
+		if cs, err = lookupCharset(p.Encoding); err != nil {
+			return nil, err
+		}
+		cs.BOMLen = bomLen
+	}
+	// BOM 不属于正文，直接跳过
+	if _, err := br.Discard(cs.BOMLen); err != nil {
+		return nil, fmt.Errorf("read txt: %w", err)
+	}
 
-	// 将转换后的 UTF-8 内容写入缓存目录
+	// 非 UTF-8 时边解码边写入缓存目录，强制指定编码时使用独立的缓存文件
 	utf8Path := filePath
-	if !utf8.Valid(raw) {
-		utf8Path = cachePath + "/content.txt"
-		if err := os.WriteFile(utf8Path, []byte(content), 0644); err != nil {
+	var src io.Reader = br
+	var startOffset int64
+	var out *bufio.Writer
+	var outFile *os.File
+	if cs.Encoding != nil {
+		name := "content.txt"
+		if p.Encoding != "" {
+			name = "content." + cs.Name + ".txt"
+		}
+		utf8Path = filepath.Join(cachePath, name)
+		outFile, err = os.Create(utf8Path)
+		if err != nil {
+			return nil, fmt.Errorf("create utf8 txt: %w", err)
+		}
+		defer outFile.Close()
+		out = bufio.NewWriterSize(outFile, txtReadBufSize)
+		src = io.TeeReader(transform.NewReader(br, cs.Encoding.NewDecoder()), out)
+	} else {
+		// 直接读取源文件时偏移需要算上跳过的 BOM
+		startOffset = int64(cs.BOMLen)
+	}
+
+	var stats wrapStats
+	chapters, err := scanChapters(ctxReader{ctx, src, job.From(ctx)}, utf8Path, startOffset, &stats)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := out.Flush(); err != nil {
+			return nil, fmt.Errorf("write utf8 txt: %w", err)
+		}
+		if err := outFile.Close(); err != nil {
 			return nil, fmt.Errorf("write utf8 txt: %w", err)
 		}
 	}
@@ -44,39 +131,158 @@ func (p *TXTParser) Parse(filePath string, cachePath string) (*model.Book, error
 		Title:     "Unknown",
 		Author:    "Unknown",
 		Format:    "txt",
+		Encoding:  cs.Name,
 		CachePath: cachePath,
+		Chapters:  chapters,
+		WrapWidth: stats.wrapWidth(),
 	}
+	return book, nil
+}
 
-	// 按正则分章
-	matches := chapterPattern.FindAllStringIndex(content, -1)
+// sampleAfterASCII 从 off 起查找第一个非 ASCII 字节，返回从该处开始的采样，之后都是 ASCII 时返回 nil。
+// 用 ReadAt 读取，不影响 f 的读取位置
+func sampleAfterASCII(ctx context.Context, f *os.File, off int64) ([]byte, error) {
+	buf := make([]byte, txtReadBufSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := f.ReadAt(buf, off)
+		if i := firstNonASCII(buf[:n]); i >= 0 {
+			n, err = f.ReadAt(buf, off+int64(i))
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return buf[:n], nil
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		off += int64(n)
+	}
+}
 
-	if len(matches) == 0 {
-		// 没有匹配到章节标题，按固定大小分割
-		book.Chapters = splitBySize(content, utf8Path, 8192)
-	} else {
-		for i, loc := range matches {
-			title := content[loc[0]:loc[1]]
-			offset := int64(loc[0])
-			var length int64
-			if i+1 < len(matches) {
-				length = int64(matches[i+1][0]) - offset
-			} else {
-				length = int64(len(content)) - offset
+// firstNonASCII 返回第一个非 ASCII 字节的位置，没有时返回 -1
+func firstNonASCII(b []byte) int {
+	for i, c := range b {
+		if c >= utf8.RuneSelf {
+			return i
+		}
+	}
+	return -1
+}
+
+// scanChapters 逐行扫描 UTF-8 文本流，按章节标题分章，同时将每行计入硬换行统计；
+// 没有匹配到任何章节标题时按固定大小分段
+func scanChapters(r io.Reader, filePath string, startOffset int64, stats *wrapStats) ([]model.Chapter, error) {
+	br := bufio.NewReaderSize(r, txtReadBufSize)
+
+	var chapters, sections []model.Chapter
+	offset, sectionStart := startOffset, startOffset
+	lineStart := true
+	for {
+		piece, err := br.ReadSlice('\n')
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, fmt.Errorf("read txt: %w", err)
+		}
+		// ErrBufferFull 表示超长行的片段，片段既不是完整行也不可能是标题
+		complete := err != bufio.ErrBufferFull
+		if len(piece) > 0 {
+			if lineStart && complete {
+				stats.add(string(bytes.TrimRight(piece, "\r\n")))
 			}
-			book.Chapters = append(book.Chapters, model.Chapter{
-				ID:       i,
-				Title:    trimTitle(title),
-				FilePath: utf8Path,
-				Offset:   offset,
-				Length:   length,
-			})
+			if lineStart && complete && len(piece) <= maxTitleLineLen {
+				line := bytes.TrimRight(piece, "\r\n")
+				if chapterPattern.Match(line) {
+					if n := len(chapters); n > 0 {
+						chapters[n-1].Length = offset - chapters[n-1].Offset
+					}
+					chapters = append(chapters, model.Chapter{
+						ID:       len(chapters),
+						Title:    trimTitle(string(line)),
+						FilePath: filePath,
+						Offset:   offset,
+					})
+					// 已找到章节标题，不再需要兜底分段
+					sections = nil
+				}
+			}
+			pieceStart := offset
+			offset += int64(len(piece))
+
+			// 兜底分段：按固定大小切分，避免在 UTF-8 多字节字符中间截断
+			for len(chapters) == 0 && offset-sectionStart >= txtSectionSize {
+				cut := max(sectionStart+txtSectionSize, pieceStart)
+				for cut < offset && !utf8.RuneStart(piece[cut-pieceStart]) {
+					cut++
+				}
+				if cut == offset && !complete && !atRuneStart(br) {
+					break
+				}
+				sections = append(sections, model.Chapter{
+					ID:       len(sections),
+					Title:    fmt.Sprintf("Section %d", len(sections)+1),
+					FilePath: filePath,
+					Offset:   sectionStart,
+					Length:   cut - sectionStart,
+				})
+				sectionStart = cut
+			}
+		}
+		lineStart = complete
+		if err == io.EOF {
+			break
 		}
 	}
 
-	return book, nil
+	if n := len(chapters); n > 0 {
+		chapters[n-1].Length = offset - chapters[n-1].Offset
+		return chapters, nil
+	}
+	if offset > sectionStart {
+		sections = append(sections, model.Chapter{
+			ID:       len(sections),
+			Title:    fmt.Sprintf("Section %d", len(sections)+1),
+			FilePath: filePath,
+			Offset:   sectionStart,
+			Length:   offset - sectionStart,
+		})
+	}
+	return sections, nil
+}
+
+// atRuneStart 判断读取器的下一个字节是否位于 UTF-8 字符起始处
+func atRuneStart(br *bufio.Reader) bool {
+	b, err := br.Peek(1)
+	return err != nil || utf8.RuneStart(b[0])
 }
 
 func (p *TXTParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	text, err := readTXTChapter(book, chapterID)
+	if err != nil {
+		return "", err
+	}
+	ch := book.Chapters[chapterID]
+	text, _ = p.Purifier.Apply(text, ch.Title, book.SourceURL, book.ID)
+	return txtToHTML(text, ch.Title, book.WrapWidth), nil
+}
+
+// PurifyHits 返回净化规则在指定章节上的命中情况
+func (p *TXTParser) PurifyHits(book *model.Book, chapterID int) ([]purify.Hit, error) {
+	text, err := readTXTChapter(book, chapterID)
+	if err != nil {
+		return nil, err
+	}
+	ch := book.Chapters[chapterID]
+	_, hits := p.Purifier.Apply(text, ch.Title, book.SourceURL, book.ID)
+	return hits, nil
+}
+
+// readTXTChapter 按偏移读取章节原始文本
+func readTXTChapter(book *model.Book, chapterID int) (string, error) {
 	if chapterID < 0 || chapterID >= len(book.Chapters) {
 		return "", fmt.Errorf("chapter %d out of range", chapterID)
 	}
@@ -97,61 +303,7 @@ func (p *TXTParser) ReadChapter(book *model.Book, chapterID int, fileURL string)
 	if err != nil && err != io.ErrUnexpectedEOF {
 		return "", fmt.Errorf("read chapter: %w", err)
 	}
-
-	// 包裹为简单 HTML 段落
-	text := string(buf[:n])
-	return txtToHTML(text), nil
-}
-
-// detectAndConvert 检测编码并转换为 UTF-8
-func detectAndConvert(data []byte) string {
-	if utf8.Valid(data) {
-		return string(data)
-	}
-
-	// 尝试 GBK 解码
-	reader := transform.NewReader(bytes.NewReader(data), simplifiedchinese.GBK.NewDecoder())
-	decoded, err := io.ReadAll(reader)
-	if err == nil && utf8.Valid(decoded) {
-		return string(decoded)
-	}
-
-	// 尝试 GB18030（GBK 超集）
-	reader = transform.NewReader(bytes.NewReader(data), simplifiedchinese.GB18030.NewDecoder())
-	decoded, err = io.ReadAll(reader)
-	if err == nil && utf8.Valid(decoded) {
-		return string(decoded)
-	}
-
-	// 兜底：强制当 UTF-8 处理，替换无效字节
-	return string(data)
-}
-
-// splitBySize 无章节标题时按字节大小分割
-func splitBySize(content string, filePath string, chunkSize int) []model.Chapter {
-	var chapters []model.Chapter
-	data := []byte(content)
-	total := len(data)
-
-	for i := 0; i < total; {
-		end := i + chunkSize
-		if end > total {
-			end = total
-		}
-		// 避免在 UTF-8 多字节字符中间截断
-		for end < total && !utf8.RuneStart(data[end]) {
-			end++
-		}
-		chapters = append(chapters, model.Chapter{
-			ID:       len(chapters),
-			Title:    fmt.Sprintf("Section %d", len(chapters)+1),
-			FilePath: filePath,
-			Offset:   int64(i),
-			Length:   int64(end - i),
-		})
-		i = end
-	}
-	return chapters
+	return string(buf[:n]), nil
 }
 
 // trimTitle 清理章节标题的前后空白
@@ -166,24 +318,6 @@ func trimTitle(s string) string {
 	return s
 }
 
-// txtToHTML 将纯文本转为简单 HTML
-func txtToHTML(text string) string {
-	var buf bytes.Buffer
-	buf.WriteString("<div class=\"txt-chapter\">")
-	scanner := bufio.NewScanner(bytes.NewReader([]byte(text)))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(bytes.TrimSpace([]byte(line))) == 0 {
-			continue
-		}
-		buf.WriteString("<p>")
-		buf.WriteString(htmlEscape(line))
-		buf.WriteString("</p>")
-	}
-	buf.WriteString("</div>")
-	return buf.String()
-}
-
 // htmlEscape 基础 HTML 转义
 func htmlEscape(s string) string {
 	s = bytes.NewBuffer(bytes.ReplaceAll([]byte(s), []byte("&"), []byte("&amp;"))).String()
@@ -0,0 +1,308 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// txtBlockKind TXT 渲染块类型
+type txtBlockKind int
+
+const (
+	blockPara    txtBlockKind = iota // 普通段落
+	blockHeading                     // 章节标题
+	blockBreak                       // 场景分隔
+	blockPre                         // 保留排版的缩进块（诗歌、书信等）
+)
+
+// txtBlock 渲染块，lines 为去掉换行符后的原始行
+type txtBlock struct {
+	kind  txtBlockKind
+	lines []string
+}
+
+// 场景分隔行：*** / * * * / ———— / ＊＊＊ / ☆☆☆ 等，至少三个符号
+var sceneBreakRe = regexp.MustCompile(`^[\s　]*(?:[*＊×#=~～\-—－_·•☆★◆◇○●※][\s　]*){3,}$`)
+
+// 自动识别文本中的网址，不包含中文标点
+var urlRe = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'，。、；：！？（）《》「」『』【】]+`)
+
+const (
+	// preIndentExtra 比正文常规缩进多出该宽度的行视为保留排版的缩进块
+	preIndentExtra = 4
+	// sceneBreakBlankLines 至少连续这么多空行才可能视为场景分隔
+	sceneBreakBlankLines = 2
+)
+
+// txtToHTML 将纯文本章节渲染为 HTML：章节标题输出为 <h2>，场景分隔输出为 <hr>，
+// 深缩进的诗歌/书信保留为 <pre>，其余行去掉行首缩进后输出为 <p>。
+// wrap 大于 0 表示源文件按该列宽硬换行，续行会被拼接回完整段落
+func txtToHTML(text string, title string, wrap int) string {
+	blocks := splitTXTBlocks(text, title, wrap)
+
+	var buf strings.Builder
+	buf.WriteString("<div class=\"txt-chapter\">")
+	for _, b := range blocks {
+		switch b.kind {
+		case blockHeading:
+			buf.WriteString("<h2 class=\"txt-heading\">")
+			buf.WriteString(htmlEscape(trimIndent(b.lines[0])))
+			buf.WriteString("</h2>")
+		case blockBreak:
+			buf.WriteString("<hr class=\"txt-break\">")
+		case blockPre:
+			buf.WriteString("<pre class=\"txt-pre\">")
+			buf.WriteString(renderInline(strings.Join(dedent(b.lines), "\n")))
+			buf.WriteString("</pre>")
+		default:
+			buf.WriteString("<p>")
+			buf.WriteString(renderInline(joinWrapped(b.lines)))
+			buf.WriteString("</p>")
+		}
+	}
+	buf.WriteString("</div>")
+	return buf.String()
+}
+
+// splitTXTBlocks 将章节文本切分为渲染块，wrap 大于 0 时合并硬换行的续行
+func splitTXTBlocks(text string, title string, wrap int) []txtBlock {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	normal := normalIndent(lines)
+	typicalGap := typicalBlankRun(lines)
+	// 硬换行文本的常规缩进是续行缩进，段首缩进要再深一级，缩进块的门槛相应提高
+	preMin := normal + preIndentExtra
+	if wrap > 0 {
+		preMin = paragraphIndent(lines, normal) + preIndentExtra
+	}
+
+	var blocks []txtBlock
+	// 上一个块之后累计的空行数
+	blank := 0
+	headingDone := title == ""
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			blank++
+			continue
+		}
+		gap := blank
+		blank = 0
+
+		// 章节标题只出现在开头
+		if !headingDone {
+			headingDone = true
+			if trimIndent(line) == title {
+				blocks = append(blocks, txtBlock{kind: blockHeading, lines: []string{line}})
+				continue
+			}
+		}
+
+		// 空行明显多于正文段间距时视为场景分隔
+		if gap >= sceneBreakBlankLines && gap > typicalGap && len(blocks) > 0 && blocks[len(blocks)-1].kind == blockPara {
+			blocks = append(blocks, txtBlock{kind: blockBreak})
+		}
+
+		if sceneBreakRe.MatchString(line) {
+			if len(blocks) > 0 && blocks[len(blocks)-1].kind != blockBreak && blocks[len(blocks)-1].kind != blockHeading {
+				blocks = append(blocks, txtBlock{kind: blockBreak})
+			}
+			continue
+		}
+
+		// 连续的深缩进行合并为一个保留排版的块，块内允许夹单个空行
+		if indentWidth(line) >= preMin {
+			pre := []string{line}
+			for i+1 < len(lines) {
+				next := lines[i+1]
+				if !isBlank(next) && indentWidth(next) >= preMin && !sceneBreakRe.MatchString(next) {
+					pre = append(pre, next)
+					i++
+					continue
+				}
+				if isBlank(next) && i+2 < len(lines) && !isBlank(lines[i+2]) && indentWidth(lines[i+2]) >= preMin {
+					pre = append(pre, "")
+					i++
+					continue
+				}
+				break
+			}
+			blocks = append(blocks, txtBlock{kind: blockPre, lines: pre})
+			continue
+		}
+
+		// 硬换行文本：紧跟在段落后的续行并入该段落
+		if n := len(blocks); wrap > 0 && gap == 0 && n > 0 && blocks[n-1].kind == blockPara {
+			last := &blocks[n-1]
+			if continuesParagraph(last.lines[len(last.lines)-1], line, wrap, normal) {
+				last.lines = append(last.lines, line)
+				continue
+			}
+		}
+
+		blocks = append(blocks, txtBlock{kind: blockPara, lines: []string{line}})
+	}
+
+	// 末尾的分隔没有意义
+	if n := len(blocks); n > 0 && blocks[n-1].kind == blockBreak {
+		blocks = blocks[:n-1]
+	}
+	return blocks
+}
+
+// renderInline 转义文本并把网址转为链接
+func renderInline(s string) string {
+	s = htmlEscape(s)
+	return urlRe.ReplaceAllStringFunc(s, func(u string) string {
+		// 句末标点不属于网址
+		trimmed := strings.TrimRight(u, ".,:!?)]")
+		rest := u[len(trimmed):]
+		href := trimmed
+		if !strings.Contains(strings.ToLower(href), "://") {
+			href = "http://" + href
+		}
+		return `<a href="` + href + `" target="_blank" rel="noopener noreferrer">` + trimmed + `</a>` + rest
+	})
+}
+
+// isBlank 判断是否空行（含全角空格）
+func isBlank(line string) bool {
+	return trimIndent(line) == ""
+}
+
+// trimIndent 去掉首尾空白及全角空格缩进，正文缩进交给前端的 text-indent
+func trimIndent(line string) string {
+	return strings.Trim(line, " \t\r　\u00a0\ufeff")
+}
+
+// indentWidth 计算行首缩进宽度：全角空格记 2，制表符记 4
+func indentWidth(line string) int {
+	w := 0
+	for _, r := range line {
+		switch r {
+		case ' ', '\u00a0':
+			w++
+		case '　':
+			w += 2
+		case '\t':
+			w += 4
+		default:
+			return w
+		}
+	}
+	return w
+}
+
+// normalIndent 统计非空行最常见的缩进宽度，作为正文常规缩进
+func normalIndent(lines []string) int {
+	counts := make(map[int]int)
+	for _, line := range lines {
+		if !isBlank(line) {
+			counts[indentWidth(line)]++
+		}
+	}
+	best, bestN := 0, 0
+	for w, n := range counts {
+		if n > bestN || (n == bestN && w < best) {
+			best, bestN = w, n
+		}
+	}
+	return best
+}
+
+// paragraphIndent 统计比常规缩进更深的最常见缩进，作为段首缩进；
+// 不足非空行的 5% 时认为没有段首缩进
+func paragraphIndent(lines []string, normal int) int {
+	counts := make(map[int]int)
+	total := 0
+	for _, line := range lines {
+		if isBlank(line) {
+			continue
+		}
+		total++
+		if w := indentWidth(line); w > normal {
+			counts[w]++
+		}
+	}
+	best, bestN := normal, 0
+	for w, n := range counts {
+		if n > bestN || (n == bestN && w < best) {
+			best, bestN = w, n
+		}
+	}
+	if bestN*20 < total {
+		return normal
+	}
+	return best
+}
+
+// typicalBlankRun 统计段落之间最常见的空行数
+func typicalBlankRun(lines []string) int {
+	counts := make(map[int]int)
+	run := 0
+	seenText := false
+	for _, line := range lines {
+		if isBlank(line) {
+			run++
+			continue
+		}
+		if seenText {
+			counts[run]++
+		}
+		seenText = true
+		run = 0
+	}
+	best, bestN := 0, 0
+	for r, n := range counts {
+		if n > bestN || (n == bestN && r < best) {
+			best, bestN = r, n
+		}
+	}
+	return best
+}
+
+// dedent 去掉缩进块中所有行共同的最小缩进，保留相对缩进
+func dedent(lines []string) []string {
+	minW := -1
+	for _, line := range lines {
+		if isBlank(line) {
+			continue
+		}
+		if w := indentWidth(line); minW < 0 || w < minW {
+			minW = w
+		}
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if isBlank(line) {
+			continue
+		}
+		w := 0
+		j := 0
+		for j < len(line) && w < minW {
+			switch line[j] {
+			case ' ':
+				w++
+				j++
+			case '\t':
+				w += 4
+				j++
+			default:
+				// 多字节空白：全角空格 / 不换行空格
+				if strings.HasPrefix(line[j:], "　") {
+					w += 2
+					j += len("　")
+				} else if strings.HasPrefix(line[j:], "\u00a0") {
+					w++
+					j += len("\u00a0")
+				} else {
+					w = minW
+				}
+			}
+		}
+		out[i] = strings.TrimRight(line[j:], " \t")
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxTrackedWidth 行宽直方图的上限，更宽的行归入最后一档
+	maxTrackedWidth = 512
+	// minWrapLines 参与统计的非空行少于该数量时不做硬换行判断
+	minWrapLines = 40
+	// minWrapWidth / maxWrapWidth 硬换行宽度的合理范围（显示列数，CJK 字符记 2 列）
+	minWrapWidth = 40
+	maxWrapWidth = 200
+)
+
+// 句末标点：以这些字符结尾的行视为段落可能结束
+const sentenceEnders = ".!?;:\"')]}。！？；：…」』”’）】》〉—"
+
+// wrapStats 逐行累计行宽分布和句末标点情况，用于判断 TXT 是否为固定列宽硬换行
+type wrapStats struct {
+	lines int
+	// width[w] 宽度为 w 的非空行数，open[w] 其中不以句末标点结尾的行数
+	width [maxTrackedWidth + 1]int
+	open  [maxTrackedWidth + 1]int
+}
+
+// add 统计一行（不含换行符）
+func (s *wrapStats) add(line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if isBlank(line) {
+		return
+	}
+	w := min(displayWidth(line), maxTrackedWidth)
+	s.lines++
+	s.width[w]++
+	if r, _ := utf8.DecodeLastRuneInString(line); !strings.ContainsRune(sentenceEnders, r) {
+		s.open[w]++
+	}
+}
+
+// wrapWidth 返回检测到的硬换行宽度，未检测到返回 0。
+// 硬换行文本的特征：绝大多数行挤在某个最大宽度附近，且这些行大多在句子中间断开
+func (s *wrapStats) wrapWidth() int {
+	if s.lines < minWrapLines {
+		return 0
+	}
+
+	// 取第 95 百分位的行宽作为换行宽度，排除少量超长行的干扰
+	limit := s.lines * 95 / 100
+	w, seen := 0, 0
+	for ; w <= maxTrackedWidth; w++ {
+		seen += s.width[w]
+		if seen >= limit {
+			break
+		}
+	}
+	if w < minWrapWidth || w > maxWrapWidth {
+		return 0
+	}
+
+	var near, open int
+	for i := w * 3 / 4; i <= w; i++ {
+		near += s.width[i]
+		open += s.open[i]
+	}
+	if near*2 < s.lines || open*2 < near {
+		return 0
+	}
+	return w
+}
+
+// displayWidth 计算行的显示宽度：CJK 全角字符记 2 列，其余记 1 列
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			w += 4
+		case isWide(r):
+			w += 2
+		default:
+			w++
+		}
+	}
+	return w
+}
+
+// isWide 判断是否为东亚全角字符
+func isWide(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r) || (r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFF60)
+}
+
+// isCJKNoSpace 判断字符两侧拼接时是否不需要空格（中日文字及全角标点；韩文按词加空格）
+func isCJKNoSpace(r rune) bool {
+	return isWide(r) && !unicode.Is(unicode.Hangul, r)
+}
+
+// continuesParagraph 判断硬换行文本中 next 是否是 prev 所在段落的续行
+func continuesParagraph(prev, next string, wrap, normal int) bool {
+	// 比常规续行缩进更深的行是新段落的首行
+	if indentWidth(next) > normal {
+		return false
+	}
+	prev = strings.TrimRight(prev, " \t\r")
+	w := displayWidth(prev)
+	// 明显短于换行宽度的行是段落末行
+	if w*5 < wrap*3 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(prev)
+	if strings.ContainsRune(sentenceEnders, r) && w*5 < wrap*4 {
+		return false
+	}
+	return true
+}
+
+// joinWrapped 将硬换行的续行拼接为一段：CJK 之间不加空格，西文之间加一个空格，
+// 行尾连字符断开的单词重新接上
+func joinWrapped(lines []string) string {
+	var out []byte
+	for i, line := range lines {
+		line = trimIndent(line)
+		if i > 0 {
+			last, _ := utf8.DecodeLastRune(out)
+			first, _ := utf8.DecodeRuneInString(line)
+			switch {
+			case isCJKNoSpace(last) || isCJKNoSpace(first):
+			case last == '-' && unicode.IsLower(first) && len(out) >= 2 && unicode.IsLetter(rune(out[len(out)-2])):
+				out = out[:len(out)-1]
+			default:
+				out = append(out, ' ')
+			}
+		}
+		out = append(out, line...)
+	}
+	return string(out)
+}
@@ -0,0 +1,354 @@
+package parser
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"ebook-reader/internal/model"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// UMDParser UMD (Union Mobile Pad) 解析器。文本型 UMD 解码为 UTF-8 文本后按 TXT 方式读取章节，
+// 图片型（漫画）UMD 每张图片为一页，格式记为 "comic"
+type UMDParser struct{}
+
+func init() {
+	Register(Format{
+		Name:         "umd",
+		Extensions:   []string{".umd"},
+		Capabilities: Capabilities{Resources: true, Cover: true, SearchText: true},
+		Sniff: func(s *Sample) (string, SniffLevel) {
+			if bytes.HasPrefix(s.Head, []byte(umdMagic)) {
+				return "", SniffExact
+			}
+			return "", SniffNone
+		},
+		New: func() Parser { return &UMDParser{} },
+	})
+}
+
+const umdMagic = "\x89\x9b\x9a\xde"
+
+// UMD 块类型
+const (
+	umdChunkHeader   = 0x01 // 类型：1 文本 / 2 图片
+	umdChunkTitle    = 0x02
+	umdChunkAuthor   = 0x03
+	umdChunkLength   = 0x0b // 解压后正文总字节数
+	umdChunkBlocks   = 0x81 // 正文数据块顺序表
+	umdChunkCover    = 0x82
+	umdChunkOffsets  = 0x83 // 章节偏移表
+	umdChunkTitles   = 0x84 // 章节标题表
+	umdChunkPageInfo = 0x87 // 各屏幕尺寸的分页信息
+)
+
+// 正文解压上限：声明的正文长度外加 umdTextSlack，声明长度缺失或超过 umdMaxText 时按 umdMaxText 计，
+// 防止少量压缩数据解压出大量内容
+const (
+	umdTextSlack = 1 << 20
+	umdMaxText   = 256 << 20
+)
+
+// umdFile 解析出的 UMD 结构
+type umdFile struct {
+	kind    byte
+	title   string
+	author  string
+	length  uint32
+	order   []uint32          // 正文数据块的校验值顺序
+	blocks  map[uint32][]byte // 校验值 -> 数据块
+	seq     []uint32          // 数据块在文件中的出现顺序
+	cover   []byte
+	offsets []uint32
+	titles  []string
+}
+
+func (p *UMDParser) Parse(ctx context.Context, filePath string, cachePath string) (*model.Book, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	u, err := parseUMD(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse umd: %w", err)
+	}
+
+	book := &model.Book{
+		Title:     "Unknown",
+		Author:    "Unknown",
+		Format:    "umd",
+		CachePath: cachePath,
+	}
+	if u.title != "" {
+		book.Title = u.title
+	}
+	if u.author != "" {
+		book.Author = u.author
+	}
+	if err := os.MkdirAll(cachePath, 0755); err != nil {
+		return nil, err
+	}
+	if ext := imageExt(u.cover); ext != "" {
+		cover := filepath.Join(cachePath, "cover"+ext)
+		if err := os.WriteFile(cover, u.cover, 0644); err != nil {
+			return nil, fmt.Errorf("write cover: %w", err)
+		}
+		book.CoverFilePath = cover
+	}
+
+	if u.kind == 2 {
+		err = u.writeComic(book)
+	} else {
+		err = u.writeText(book)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(book.Chapters) == 0 {
+		return nil, errors.New("umd: no content")
+	}
+	return book, nil
+}
+
+func (p *UMDParser) ReadChapter(book *model.Book, chapterID int, fileURL string) (string, error) {
+	text, err := readTXTChapter(book, chapterID)
+	if err != nil {
+		return "", err
+	}
+	return txtToHTML(text, book.Chapters[chapterID].Title, 0), nil
+}
+
+// parseUMD 顺序读取 '#' 块（id + 定长数据）与其后的 '$' 附加数据块。
+// 目录、封面、偏移表、标题表等块之后紧跟一个属于它的附加块，其余附加块为正文数据
+func parseUMD(data []byte) (*umdFile, error) {
+	if !bytes.HasPrefix(data, []byte(umdMagic)) {
+		return nil, errors.New("not a umd file")
+	}
+	u := &umdFile{kind: 1, blocks: make(map[uint32][]byte)}
+	pending := 0 // 等待附加块的块类型
+	pos := len(umdMagic)
+	for pos < len(data) {
+		switch data[pos] {
+		case '#':
+			if pos+5 > len(data) {
+				return nil, errors.New("truncated chunk")
+			}
+			id := int(binary.LittleEndian.Uint16(data[pos+1:]))
+			size := int(data[pos+4])
+			if size < 5 || pos+size > len(data) {
+				return nil, fmt.Errorf("bad chunk 0x%x length", id)
+			}
+			body := data[pos+5 : pos+size]
+			pos += size
+			pending = 0
+			switch id {
+			case umdChunkHeader:
+				if len(body) > 0 {
+					u.kind = body[0]
+				}
+			case umdChunkTitle:
+				u.title = strings.TrimSpace(decodeUTF16LE(body))
+			case umdChunkAuthor:
+				u.author = strings.TrimSpace(decodeUTF16LE(body))
+			case umdChunkLength:
+				if len(body) >= 4 {
+					u.length = binary.LittleEndian.Uint32(body)
+				}
+			case umdChunkBlocks, umdChunkCover, umdChunkOffsets, umdChunkTitles, umdChunkPageInfo:
+				pending = id
+			}
+		case '$':
+			if pos+9 > len(data) {
+				return nil, errors.New("truncated data block")
+			}
+			check := binary.LittleEndian.Uint32(data[pos+1:])
+			size := int(binary.LittleEndian.Uint32(data[pos+5:]))
+			if size < 9 || size > len(data)-pos {
+				return nil, errors.New("bad data block length")
+			}
+			body := data[pos+9 : pos+size]
+			pos += size
+			switch pending {
+			case umdChunkBlocks:
+				for i := 0; i+4 <= len(body); i += 4 {
+					u.order = append(u.order, binary.LittleEndian.Uint32(body[i:]))
+				}
+			case umdChunkCover:
+				u.cover = body
+			case umdChunkOffsets:
+				for i := 0; i+4 <= len(body); i += 4 {
+					u.offsets = append(u.offsets, binary.LittleEndian.Uint32(body[i:]))
+				}
+			case umdChunkTitles:
+				for i := 0; i < len(body); {
+					n := int(body[i])
+					if i+1+n > len(body) {
+						break
+					}
+					u.titles = append(u.titles, strings.TrimSpace(decodeUTF16LE(body[i+1:i+1+n])))
+					i += 1 + n
+				}
+			case umdChunkPageInfo:
+			default:
+				if _, ok := u.blocks[check]; !ok {
+					u.seq = append(u.seq, check)
+				}
+				u.blocks[check] = body
+			}
+			pending = 0
+		default:
+			// 已读到正文时容忍文件尾部的垃圾数据
+			if len(u.blocks) > 0 {
+				return u, nil
+			}
+			return nil, fmt.Errorf("unexpected byte 0x%02x at %d", data[pos], pos)
+		}
+	}
+	return u, nil
+}
+
+// contentBlocks 按目录顺序返回正文数据块，目录缺失或不完整时按文件中的出现顺序
+func (u *umdFile) contentBlocks() [][]byte {
+	order := u.order
+	for _, c := range order {
+		if _, ok := u.blocks[c]; !ok {
+			order = nil
+			break
+		}
+	}
+	if len(order) == 0 {
+		order = u.seq
+	}
+	out := make([][]byte, 0, len(order))
+	for _, c := range order {
+		out = append(out, u.blocks[c])
+	}
+	return out
+}
+
+// writeText 解压正文，按章节偏移表（UTF-16LE 字节偏移）切分后以 UTF-8 写入 text.txt
+func (u *umdFile) writeText(book *model.Book) error {
+	limit := int64(umdMaxText)
+	if u.length > 0 && u.length < umdMaxText {
+		limit = int64(u.length)
+	}
+	limit += umdTextSlack
+	var raw bytes.Buffer
+	for _, b := range u.contentBlocks() {
+		zr, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("umd content: %w", err)
+		}
+		_, err = io.Copy(&raw, io.LimitReader(zr, limit-int64(raw.Len())+1))
+		zr.Close()
+		if err != nil {
+			return fmt.Errorf("umd content: %w", err)
+		}
+		if int64(raw.Len()) > limit {
+			return fmt.Errorf("umd content: decompressed size exceeds %d bytes", limit)
+		}
+	}
+	text := raw.Bytes()
+	if u.length > 0 && int(u.length) < len(text) {
+		text = text[:u.length]
+	}
+
+	offsets := u.offsets
+	if len(offsets) == 0 {
+		offsets = []uint32{0}
+	}
+	textPath := filepath.Join(book.CachePath, "text.txt")
+	out, err := os.Create(textPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var pos int64
+	for i, start := range offsets {
+		end := uint32(len(text))
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		start, end = min(start&^1, uint32(len(text))), min(end&^1, uint32(len(text)))
+		if end < start {
+			end = start
+		}
+		// UMD 用 U+2029 段落分隔符表示换行
+		s := strings.ReplaceAll(decodeUTF16LE(text[start:end]), "\u2029", "\n")
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+		n, err := io.WriteString(out, s)
+		if err != nil {
+			return fmt.Errorf("write text: %w", err)
+		}
+		title := ""
+		if i < len(u.titles) {
+			title = u.titles[i]
+		}
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		book.Chapters = append(book.Chapters, model.Chapter{
+			ID:       i,
+			Title:    title,
+			FilePath: textPath,
+			Offset:   pos,
+			Length:   int64(n),
+		})
+		pos += int64(n)
+	}
+	return out.Close()
+}
+
+// writeComic 图片型 UMD：每个数据块是一张图片，章节偏移为起始页序号
+func (u *umdFile) writeComic(book *model.Book) error {
+	book.Format = "comic"
+	pageDir := filepath.Join(book.CachePath, "pages")
+	if err := os.MkdirAll(pageDir, 0755); err != nil {
+		return err
+	}
+	bookmarks := make(map[int]string)
+	for i, off := range u.offsets {
+		if i < len(u.titles) && u.titles[i] != "" {
+			if _, ok := bookmarks[int(off)]; !ok {
+				bookmarks[int(off)] = u.titles[i]
+			}
+		}
+	}
+	for _, img := range u.contentBlocks() {
+		ext := imageExt(img)
+		if ext == "" {
+			continue
+		}
+		id := len(book.Chapters)
+		file := filepath.Join(pageDir, fmt.Sprintf("%05d%s", id+1, ext))
+		if err := os.WriteFile(file, img, 0644); err != nil {
+			return fmt.Errorf("write page: %w", err)
+		}
+		title := bookmarks[id]
+		if title == "" {
+			title = fmt.Sprintf("Page %d", id+1)
+		}
+		book.Chapters = append(book.Chapters, model.Chapter{ID: id, Title: title, FilePath: file})
+	}
+	if book.CoverFilePath == "" && len(book.Chapters) > 0 {
+		book.CoverFilePath = book.Chapters[0].FilePath
+	}
+	return nil
+}
+
+// decodeUTF16LE 解码 UTF-16LE 字节，忽略末尾不成对的字节
+func decodeUTF16LE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// xmlNode 精简的 XML 节点树，name 为空表示文本节点。
+// 元素名和属性名只保留本地名（w:val / l:href / xlink:href 分别存为 val / href）
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*xmlNode
+}
+
+// child 返回第一个指定名称的子节点
+func (n *xmlNode) child(name string) *xmlNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// find 深度优先返回第一个指定名称的后代节点
+func (n *xmlNode) find(name string) *xmlNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+		if f := c.find(name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// textContent 返回节点下所有文本，按空白归一化
+func (n *xmlNode) textContent() string {
+	var buf strings.Builder
+	var walk func(*xmlNode)
+	walk = func(n *xmlNode) {
+		if n.name == "" {
+			buf.WriteString(n.text)
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+			// 块级元素之间补空格，避免标题多个 <p> 粘在一起
+			if c.name == "p" || c.name == "v" {
+				buf.WriteByte(' ')
+			}
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// parseXMLTree 把整个文档读成节点树，返回虚拟的 #document 根节点。
+// hook 非空时在每个开始标签调用，返回 true 表示该元素已由 hook 自行消费
+func parseXMLTree(d *xml.Decoder, hook func(start xml.StartElement) (bool, error)) (*xmlNode, error) {
+	root := &xmlNode{name: "#document"}
+	stack := []*xmlNode{root}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if hook != nil {
+				done, err := hook(t)
+				if err != nil {
+					return nil, err
+				}
+				if done {
+					continue
+				}
+			}
+			n := &xmlNode{name: t.Name.Local, attrs: make(map[string]string, len(t.Attr))}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			top.children = append(top.children, &xmlNode{text: string(t)})
+		}
+	}
+	return root, nil
+}
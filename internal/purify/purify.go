@@ -0,0 +1,233 @@
+package purify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Action 规则动作
+const (
+	ActionDelete  = "delete"  // 删除匹配的整行
+	ActionReplace = "replace" // 将匹配内容替换为 Replacement
+)
+
+// Rule 净化规则。Hosts 与 Books 都为空时全局生效，
+// 否则只对来源主机在 Hosts 中、或书籍 ID/URL 在 Books 中的书生效
+type Rule struct {
+	Name        string   `json:"name"`
+	Pattern     string   `json:"pattern"`
+	Action      string   `json:"action"`
+	Replacement string   `json:"replacement,omitempty"`
+	Hosts       []string `json:"hosts,omitempty"`
+	Books       []string `json:"books,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Hit 某条规则在一次净化中的命中情况
+type Hit struct {
+	Rule  string `json:"rule"`
+	Scope string `json:"scope"`
+	Count int    `json:"count"`
+}
+
+// Config 规则配置文件结构
+type Config struct {
+	// DisableBuiltin 按名称禁用内置规则，"*" 禁用全部
+	DisableBuiltin []string `json:"disableBuiltin"`
+	Rules          []Rule   `json:"rules"`
+}
+
+// Purifier 净化管道：依次执行内置规则和配置规则
+type Purifier struct {
+	rules []*Rule
+	// dedupTitle 删除正文中重复出现的章节标题
+	dedupTitle bool
+}
+
+// dedupTitleRule 内置的重复章节标题规则名
+const dedupTitleRule = "duplicate-title"
+
+// builtinRules 内置规则，针对常见的盗版/采集站垃圾内容
+var builtinRules = []Rule{
+	{Name: "page-continue", Action: ActionDelete, Pattern: `本章未完.{0,6}(点击|请|翻页|下一页)|\(本章完\)|（本章完）`},
+	{Name: "mobile-visit", Action: ActionDelete, Pattern: `手机(用户|版|阅读).{0,8}(访问|浏览|阅读)|请记住本书首发域名|天才一秒记住|最快更新.{0,20}最新章节|本书由.{0,10}首发`},
+	{Name: "ad-url-line", Action: ActionDelete, Pattern: `^[\s　]*(https?://|www\.)[^\s]+[\s　]*$`},
+	{Name: "site-watermark", Action: ActionReplace, Pattern: `[（(【\[]?\s*(笔趣阁|顶点小说|八一中文网|新笔趣阁|飘天文学|69书吧|UU看书|uu看书)\s*[\w.]*\s*[）)】\]]?`},
+	{Name: "inline-ad-url", Action: ActionReplace, Pattern: `[（(]\s*(https?://)?www\.[a-zA-Z0-9\-]+\.(com|net|org|cc|la|info|co)\s*[）)]`},
+}
+
+// New 创建净化器，cfg 为 nil 时只启用内置规则
+func New(cfg *Config) (*Purifier, error) {
+	disabled := make(map[string]bool)
+	var rules []Rule
+	if cfg != nil {
+		for _, name := range cfg.DisableBuiltin {
+			disabled[name] = true
+		}
+		rules = cfg.Rules
+	}
+
+	p := &Purifier{dedupTitle: !disabled["*"] && !disabled[dedupTitleRule]}
+	for _, r := range builtinRules {
+		if disabled["*"] || disabled[r.Name] {
+			continue
+		}
+		if err := p.add(r); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range rules {
+		if err := p.add(r); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Load 从 JSON 文件加载配置规则，path 为空时只启用内置规则
+func Load(path string) (*Purifier, error) {
+	if path == "" {
+		return New(nil)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse rules: %w", err)
+	}
+	return New(&cfg)
+}
+
+func (p *Purifier) add(r Rule) error {
+	if r.Action == "" {
+		r.Action = ActionDelete
+	}
+	if r.Action != ActionDelete && r.Action != ActionReplace {
+		return fmt.Errorf("rule %q: unknown action %q", r.Name, r.Action)
+	}
+	// 删除规则按行匹配，替换规则作用于整段文本，均开启多行模式
+	re, err := regexp.Compile("(?m)" + r.Pattern)
+	if err != nil {
+		return fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	r.re = re
+	p.rules = append(p.rules, &r)
+	return nil
+}
+
+// Apply 净化一章文本，返回净化后的文本和命中的规则。
+// title 为章节标题，sourceURL 与 bookID 用于匹配规则作用域
+func (p *Purifier) Apply(text, title, sourceURL, bookID string) (string, []Hit) {
+	if p == nil {
+		return text, nil
+	}
+	host := hostOf(sourceURL)
+
+	var hits []Hit
+	for _, r := range p.rules {
+		if !r.applies(host, sourceURL, bookID) {
+			continue
+		}
+		var n int
+		if r.Action == ActionDelete {
+			text, n = deleteLines(text, func(line string) bool { return r.re.MatchString(line) })
+		} else {
+			n = len(r.re.FindAllStringIndex(text, -1))
+			if n > 0 {
+				text = r.re.ReplaceAllString(text, r.Replacement)
+			}
+		}
+		if n > 0 {
+			hits = append(hits, Hit{Rule: r.Name, Scope: r.scope(), Count: n})
+		}
+	}
+
+	// 采集站常在正文里重复章节标题，保留第一次出现（渲染为标题），删除其余
+	if p.dedupTitle && title != "" {
+		seen := false
+		var n int
+		text, n = deleteLines(text, func(line string) bool {
+			if strings.TrimSpace(line) != title {
+				return false
+			}
+			if !seen {
+				seen = true
+				return false
+			}
+			return true
+		})
+		if n > 0 {
+			hits = append(hits, Hit{Rule: dedupTitleRule, Scope: "global", Count: n})
+		}
+	}
+	return text, hits
+}
+
+// applies 判断规则是否作用于该书
+func (r *Rule) applies(host, sourceURL, bookID string) bool {
+	if len(r.Hosts) == 0 && len(r.Books) == 0 {
+		return true
+	}
+	for _, h := range r.Hosts {
+		h = strings.ToLower(h)
+		// 支持 "example.com" 同时匹配其子域名
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	for _, b := range r.Books {
+		if b == bookID || b == sourceURL {
+			return true
+		}
+	}
+	return false
+}
+
+// scope 返回规则作用域的描述
+func (r *Rule) scope() string {
+	var parts []string
+	for _, h := range r.Hosts {
+		parts = append(parts, "host:"+h)
+	}
+	for _, b := range r.Books {
+		parts = append(parts, "book:"+b)
+	}
+	if len(parts) == 0 {
+		return "global"
+	}
+	return strings.Join(parts, ",")
+}
+
+// deleteLines 删除满足条件的行，返回新文本和删除的行数
+func deleteLines(text string, match func(line string) bool) (string, int) {
+	lines := strings.SplitAfter(text, "\n")
+	out := lines[:0]
+	n := 0
+	for _, line := range lines {
+		if match(strings.TrimRight(line, "\r\n")) {
+			n++
+			continue
+		}
+		out = append(out, line)
+	}
+	if n == 0 {
+		return text, 0
+	}
+	return strings.Join(out, ""), n
+}
+
+// hostOf 提取 URL 的主机名（小写，不含端口）
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"ebook-reader/internal/job"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// jobWait 异步加载时先等待的时间，缓存命中或小文件在此期间完成时直接返回元数据
+	jobWait = 500 * time.Millisecond
+	// jobRetention 任务结束后保留的时间，供稍后才订阅进度的客户端取得结果；
+	// 进行中的任务无人等待或订阅进度超过该时间后取消
+	jobRetention = time.Minute
+	// eventInterval 推送进度的最短间隔，下载时进度变化频繁
+	eventInterval = 200 * time.Millisecond
+)
+
+// jobEvent SSE 推送的进度消息，失败时附带错误信息和对应的状态码
+type jobEvent struct {
+	job.Progress
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+// awaitJob 在后台任务中加载书籍，同一本书的请求共享一个任务。任务不随单个请求取消，
+// 等待中的请求和进度订阅都离开超过 jobRetention 后才取消。
+// jobWait 内完成时返回 true，由调用方从缓存读取元数据；否则返回 202 和任务 ID，
+// 客户端订阅 /api/job/events/{id}，完成后再次请求元数据。返回 false 表示已写入响应
+func (s *Server) awaitJob(w http.ResponseWriter, r *http.Request, bq bookQuery) bool {
+	// 带一次性请求头的请求按请求头的 HMAC 区分，不与匿名请求共享任务；
+	// &refresh=1 的请求不复用未重新验证的任务
+	key := s.dl.CacheKey(s.dl.WithHeader(r.Context(), bq.fileURL, bq.header), bq.fileURL) + "\x00" + bq.entry + "\x00" + bq.encoding
+	if bq.refresh {
+		key += "\x00refresh"
+	}
+	j := s.jobs.Start(key, func(ctx context.Context) error {
+		ctx = s.dl.WithHeader(ctx, bq.fileURL, bq.header)
+		s.revalidate(ctx, bq)
+		_, _, err := s.resolveBook(ctx, bq.fileURL, bq.encoding, bq.entry)
+		if err != nil {
+			log.Printf("resolveBook error: %v", err)
+		}
+		return err
+	})
+	defer j.Hold()()
+
+	timer := time.NewTimer(jobWait)
+	defer timer.Stop()
+	select {
+	case <-j.Done():
+		if err := j.Err(); err != nil {
+			code, msg := errorStatus(err)
+			http.Error(w, `{"error":"`+msg+`"}`, code)
+			return false
+		}
+		return true
+	case <-r.Context().Done():
+		return false
+	case <-timer.C:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"jobId":     j.ID,
+		"eventsUrl": "/api/job/events/" + j.ID,
+	})
+	return false
+}
+
+// handleJobEvents 以 Server-Sent Events 推送任务进度，每条消息为一个 jobEvent，
+// phase 为 done / error 的消息之后关闭连接
+func (s *Server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/job/events/")
+	j, ok := s.jobs.Get(id)
+	if !ok {
+		http.Error(w, `{"error":"job not found"}`, http.StatusNotFound)
+		return
+	}
+	defer j.Hold()()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		p, changed := j.Watch()
+		ev := jobEvent{Progress: p}
+		if p.Phase == job.PhaseError {
+			ev.Status, ev.Error = errorStatus(j.Err())
+		}
+		data, _ := json.Marshal(ev)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return
+		}
+		if p.Phase == job.PhaseDone || p.Phase == job.PhaseError {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		select {
+		case <-time.After(eventInterval):
+		case <-j.Done():
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
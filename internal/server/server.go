@@ -1,17 +1,26 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
 	"ebook-reader/internal/cache"
+	"ebook-reader/internal/chconv"
 	"ebook-reader/internal/downloader"
+	"ebook-reader/internal/job"
 	"ebook-reader/internal/model"
 	"ebook-reader/internal/parser"
+	"ebook-reader/internal/purify"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -19,14 +28,20 @@ import (
 
 // Server HTTP 服务
 type Server struct {
-	dl     *downloader.Downloader
-	cache  *cache.Cache
-	static fs.FS
+	dl       *downloader.Downloader
+	cache    *cache.Cache
+	purifier *purify.Purifier
+	static   fs.FS
+	jobs     *job.Registry
+	// MarkdownSplitLevel Markdown 按 1..N 级标题切分章节，0 使用解析器默认值
+	MarkdownSplitLevel int
+	// APIToken 允许以 POST 请求书籍接口并附带一次性请求头的调用方令牌，空表示不开放
+	APIToken string
 }
 
 // New 创建服务实例
-func New(dl *downloader.Downloader, c *cache.Cache, static fs.FS) *Server {
-	return &Server{dl: dl, cache: c, static: static}
+func New(dl *downloader.Downloader, c *cache.Cache, pur *purify.Purifier, static fs.FS) *Server {
+	return &Server{dl: dl, cache: c, purifier: pur, static: static, jobs: job.NewRegistry(jobRetention, jobRetention)}
 }
 
 // Handler 返回注册好路由的 http.Handler
@@ -34,76 +49,319 @@ func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/book/meta", s.handleMeta)
 	mux.HandleFunc("/api/book/chapter/", s.handleChapter)
+	// /api/book/purify/{chapterID}?file=... 列出章节命中的净化规则
+	mux.HandleFunc("/api/book/purify/", s.handlePurify)
 	mux.HandleFunc("/api/book/cover/", s.handleCover)
+	// /api/book/original/{hash} 原始文件直通，支持 Range
+	mux.HandleFunc("/api/book/original/", s.handleOriginal)
 	// /api/book/resource/{hash}/{path...}
 	mux.HandleFunc("/api/book/resource/", s.handleResource)
+	// /api/formats 列出支持的格式及其能力
+	mux.HandleFunc("/api/formats", s.handleFormats)
+	// /api/job/events/{id} 以 SSE 推送异步加载任务的进度
+	mux.HandleFunc("/api/job/events/", s.handleJobEvents)
 	mux.Handle("/", http.FileServer(http.FS(s.static)))
 	return mux
 }
 
 // resolveBook 下载 + 解析 + 缓存，返回 book 和对应的 parser
-func (s *Server) resolveBook(fileURL string) (*model.Book, parser.Parser, error) {
-	hash := downloader.URLHash(fileURL)
+// encoding 非空时强制以该编码重新解码 TXT 源文件，结果按编码单独缓存；
+// entry 非空时打开压缩包内的指定书籍，与压缩包本身分开缓存；
+// ctx 取消（如客户端断开）时放弃下载和解析
+func (s *Server) resolveBook(ctx context.Context, fileURL string, encoding string, entry string) (*model.Book, parser.Parser, error) {
+	hash := s.dl.CacheKey(ctx, fileURL)
+	if entry != "" {
+		hash = s.dl.CacheKey(ctx, fileURL+"#entry="+entry)
+	}
 
-	// 内存缓存命中
-	if book, ok := s.cache.Get(hash); ok {
-		p, err := parser.GetParser(book.Format)
+	// 内存缓存命中（强制编码只对 TXT 生效，其他格式直接复用）
+	if book, ok := s.cache.Get(hash); ok && (encoding == "" || book.Format != "txt") {
+		p, err := s.parserFor(book.Format)
 		if err != nil {
 			return nil, nil, err
 		}
 		return book, p, nil
 	}
+	if encoding != "" {
+		if book, ok := s.cache.Get(hash + "@" + encoding); ok {
+			p, err := s.parserFor(book.Format)
+			if err != nil {
+				return nil, nil, err
+			}
+			return book, p, nil
+		}
+	}
 
 	// 下载
-	filePath, cachePath, err := s.dl.Download(fileURL)
+	filePath, cachePath, err := s.dl.Download(ctx, fileURL)
 	if err != nil {
 		return nil, nil, fmt.Errorf("download: %w", err)
 	}
 
 	// 解析
-	p, err := parser.GetParser(filePath)
+	p, err := s.parserFor(filePath)
 	if err != nil {
 		return nil, nil, err
 	}
+	// 按源地址下载相对图片的格式
+	switch tp := p.(type) {
+	case *parser.TXTParser:
+		tp.Encoding = encoding
+	case *parser.MarkdownParser:
+		tp.SourceURL = fileURL
+		tp.Fetch = s.dl.FetchResource
+	case *parser.HTMLParser:
+		tp.SourceURL = fileURL
+		tp.Fetch = s.dl.FetchResource
+	case *parser.ArchiveParser:
+		tp.Entry = entry
+		tp.Name = archiveName(fileURL)
+		tp.Inner = func(name string) (parser.Parser, error) {
+			ip, err := s.parserFor(name)
+			if txt, ok := ip.(*parser.TXTParser); ok {
+				txt.Encoding = encoding
+			}
+			return ip, err
+		}
+	}
 
-	book, err := p.Parse(filePath, cachePath)
+	job.From(ctx).SetPhase(job.PhaseParse)
+	book, err := p.Parse(ctx, filePath, cachePath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("parse: %w", err)
 	}
+	// 压缩包内的单本书之后按其自身格式读取
+	if _, ok := p.(*parser.ArchiveParser); ok && book.Format != "collection" {
+		if p, err = s.parserFor(book.Format); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	book.ID = hash
+	book.SourceURL = fileURL
+	if book.SourceFile == "" {
+		book.SourceFile = filePath
+	}
+	book.OriginalURL = "/api/book/original/" + hash
 	if book.CoverFilePath != "" {
 		book.CoverURL = "/api/book/cover/" + hash
 	}
 
 	// 存入内存缓存
-	s.cache.Put(hash, book)
+	s.cache.Put(cacheKey(book, encoding), book)
 
 	return book, p, nil
 }
 
-func (s *Server) handleMeta(w http.ResponseWriter, r *http.Request) {
-	fileURL := r.URL.Query().Get("file")
-	if fileURL == "" {
+// cacheKey 返回书籍在内存缓存中的键，强制编码的 TXT 按编码单独缓存
+func cacheKey(book *model.Book, encoding string) string {
+	if encoding != "" && book.Format == "txt" {
+		return book.ID + "@" + encoding
+	}
+	return book.ID
+}
+
+// archiveName 取 URL 路径的文件名作为压缩包合集的书名
+func archiveName(fileURL string) string {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return ""
+	}
+	name, _ := url.PathUnescape(path.Base(u.Path))
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// parserFor 获取解析器并注入服务端配置
+func (s *Server) parserFor(nameOrFormat string) (parser.Parser, error) {
+	p, err := parser.GetParser(nameOrFormat)
+	if err != nil {
+		return nil, err
+	}
+	if tp, ok := p.(*parser.TXTParser); ok {
+		tp.Purifier = s.purifier
+	}
+	if mp, ok := p.(*parser.MarkdownParser); ok {
+		mp.SplitLevel = s.MarkdownSplitLevel
+	}
+	return p, nil
+}
+
+// bookQuery 书籍相关接口的公共查询参数
+type bookQuery struct {
+	fileURL  string
+	entry    string            // &entry= 压缩包内的书籍路径
+	encoding string            // &encoding= 强制 TXT 编码
+	conv     *chconv.Converter // &convert= 简繁转换，nil 表示不转换
+	refresh  bool              // &refresh=1 向源站重新验证文件是否更新
+	async    bool              // &async=1 未能很快加载完时返回 202 和任务 ID
+	header   http.Header       // POST 请求体中的一次性请求头，只用于下载
+}
+
+// bookParams 解析 ?file= 与可选的 &entry= / &encoding= / &convert= / &refresh= / &async= 参数，出错时已写入响应
+func bookParams(w http.ResponseWriter, r *http.Request) (bookQuery, bool) {
+	q := r.URL.Query()
+	bq := bookQuery{fileURL: q.Get("file"), entry: q.Get("entry"), refresh: q.Get("refresh") == "1", async: q.Get("async") == "1"}
+	if bq.fileURL == "" {
 		http.Error(w, `{"error":"missing file parameter"}`, http.StatusBadRequest)
+		return bq, false
+	}
+	if v := q.Get("encoding"); v != "" {
+		enc, err := parser.NormalizeEncoding(v)
+		if err != nil {
+			http.Error(w, `{"error":"unsupported encoding"}`, http.StatusBadRequest)
+			return bq, false
+		}
+		bq.encoding = enc
+	}
+	if v := q.Get("convert"); v != "" {
+		conv, err := chconv.Get(v)
+		if err != nil {
+			http.Error(w, `{"error":"unsupported convert"}`, http.StatusBadRequest)
+			return bq, false
+		}
+		bq.conv = conv
+	}
+	return bq, true
+}
+
+// bookRequest 解析书籍接口的参数；POST 请求另读取可信调用方的一次性请求头，
+// 返回的 ctx 带有这些请求头，书籍按请求头单独缓存，之后的章节等请求须以相同的请求头 POST
+func (s *Server) bookRequest(w http.ResponseWriter, r *http.Request) (bookQuery, context.Context, bool) {
+	bq, ok := bookParams(w, r)
+	if !ok {
+		return bq, nil, false
+	}
+	if r.Method == http.MethodPost {
+		if bq.header, ok = s.trustedHeader(w, r); !ok {
+			return bq, nil, false
+		}
+	}
+	return bq, s.dl.WithHeader(r.Context(), bq.fileURL, bq.header), true
+}
+
+// trustedHeader 读取 POST 请求体 {"headers":{...}} 中的一次性请求头，
+// 用于下载需要临时凭据的源站。只接受携带 Authorization: Bearer <APIToken> 的调用方，出错时已写入响应
+func (s *Server) trustedHeader(w http.ResponseWriter, r *http.Request) (http.Header, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.APIToken == "" || !found || subtle.ConstantTimeCompare([]byte(token), []byte(s.APIToken)) != 1 {
+		http.Error(w, `{"error":"forbidden"}`, http.StatusForbidden)
+		return nil, false
+	}
+	var body struct {
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	header := http.Header{}
+	for k, v := range body.Headers {
+		header.Set(k, v)
+	}
+	return header, true
+}
+
+// bookError 书籍加载失败时写入错误响应，状态码见 errorStatus
+func bookError(w http.ResponseWriter, err error) {
+	// 客户端已断开，无需响应
+	if errors.Is(err, context.Canceled) {
 		return
 	}
+	log.Printf("resolveBook error: %v", err)
+	code, msg := errorStatus(err)
+	http.Error(w, `{"error":"`+msg+`"}`, code)
+}
 
-	book, _, err := s.resolveBook(fileURL)
+// errorStatus 返回书籍加载错误对应的状态码和错误信息：DRM 保护的书返回 422、
+// 超出下载或解压限制返回 413 / 415 / 504、出站策略不允许返回 403 以便前端提示，其他错误返回 500
+func errorStatus(err error) (int, string) {
+	var drm *parser.DRMError
+	switch {
+	case errors.As(err, &drm):
+		return http.StatusUnprocessableEntity, "drm protected"
+	case errors.Is(err, downloader.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge, "file too large"
+	case errors.Is(err, downloader.ErrContentType):
+		return http.StatusUnsupportedMediaType, "content type not allowed"
+	case errors.Is(err, downloader.ErrConnectTimeout):
+		return http.StatusGatewayTimeout, "connect timeout"
+	case errors.Is(err, downloader.ErrHeaderTimeout):
+		return http.StatusGatewayTimeout, "response header timeout"
+	case errors.Is(err, downloader.ErrTimeout):
+		return http.StatusGatewayTimeout, "download timeout"
+	case errors.Is(err, downloader.ErrForbidden):
+		return http.StatusForbidden, "destination not allowed"
+	case errors.Is(err, downloader.ErrTooManyRedirects):
+		return http.StatusBadGateway, "too many redirects"
+	case errors.Is(err, parser.ErrExtractTooLarge):
+		return http.StatusRequestEntityTooLarge, "archive content too large"
+	}
+	return http.StatusInternalServerError, "failed to load book"
+}
+
+func (s *Server) handleMeta(w http.ResponseWriter, r *http.Request) {
+	bq, ctx, ok := s.bookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if bq.async {
+		if !s.awaitJob(w, r, bq) {
+			return
+		}
+	} else {
+		s.revalidate(ctx, bq)
+	}
+	book, _, err := s.resolveBook(ctx, bq.fileURL, bq.encoding, bq.entry)
 	if err != nil {
-		log.Printf("resolveBook error: %v", err)
-		http.Error(w, `{"error":"failed to load book"}`, http.StatusInternalServerError)
+		bookError(w, err)
 		return
 	}
 
+	if bq.conv != nil {
+		book = convertMeta(book, bq.conv)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(book)
 }
 
+// revalidate 请求带 &refresh=1 或距上次验证超过设定间隔时向源站检查文件是否更新，
+// 有更新则丢弃该文件（含压缩包内各条目、各编码变体）已解析的书籍和章节缓存，随后重新解析。
+// 验证失败时继续使用已下载的文件
+func (s *Server) revalidate(ctx context.Context, bq bookQuery) {
+	if !bq.refresh && !s.dl.RevalidateDue(ctx, bq.fileURL) {
+		return
+	}
+	changed, err := s.dl.Revalidate(ctx, bq.fileURL)
+	if err != nil {
+		log.Printf("revalidate error: %v", err)
+		return
+	}
+	if changed {
+		s.cache.InvalidateDir(filepath.Join(s.cache.DataDir(), s.dl.CacheKey(ctx, bq.fileURL)))
+	}
+}
+
+// convertMeta 返回书名、作者、章节标题经过简繁转换的副本，不修改缓存中的对象
+func convertMeta(book *model.Book, conv *chconv.Converter) *model.Book {
+	b := *book
+	b.Title = conv.Convert(book.Title)
+	b.Author = conv.Convert(book.Author)
+	b.Chapters = make([]model.Chapter, len(book.Chapters))
+	for i, ch := range book.Chapters {
+		ch.Title = conv.Convert(ch.Title)
+		b.Chapters[i] = ch
+	}
+	return &b
+}
+
 func (s *Server) handleChapter(w http.ResponseWriter, r *http.Request) {
-	fileURL := r.URL.Query().Get("file")
-	if fileURL == "" {
-		http.Error(w, `{"error":"missing file parameter"}`, http.StatusBadRequest)
+	bq, ctx, ok := s.bookRequest(w, r)
+	if !ok {
 		return
 	}
 
@@ -115,13 +373,21 @@ func (s *Server) handleChapter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	book, p, err := s.resolveBook(fileURL)
+	book, p, err := s.resolveBook(ctx, bq.fileURL, bq.encoding, bq.entry)
 	if err != nil {
-		log.Printf("resolveBook error: %v", err)
-		http.Error(w, `{"error":"failed to load book"}`, http.StatusInternalServerError)
+		bookError(w, err)
 		return
 	}
 
+	// 简繁转换结果按转换方式缓存
+	key := cacheKey(book, bq.encoding)
+	if bq.conv != nil {
+		if content, ok := s.cache.GetChapter(key, bq.conv.Mode(), chapterID); ok {
+			writeChapter(w, content)
+			return
+		}
+	}
+
 	content, err := p.ReadChapter(book, chapterID, book.ID)
 	if err != nil {
 		log.Printf("readChapter error: %v", err)
@@ -129,10 +395,56 @@ func (s *Server) handleChapter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if bq.conv != nil {
+		content = bq.conv.ConvertHTML(content)
+		s.cache.PutChapter(key, bq.conv.Mode(), chapterID, content)
+	}
+	writeChapter(w, content)
+}
+
+func writeChapter(w http.ResponseWriter, content string) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"content": content})
 }
 
+func (s *Server) handlePurify(w http.ResponseWriter, r *http.Request) {
+	bq, ctx, ok := s.bookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// /api/book/purify/3
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/book/purify/")
+	chapterID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, `{"error":"invalid chapter id"}`, http.StatusBadRequest)
+		return
+	}
+
+	book, p, err := s.resolveBook(ctx, bq.fileURL, bq.encoding, bq.entry)
+	if err != nil {
+		bookError(w, err)
+		return
+	}
+
+	// 只有 TXT 有净化管道，其他格式返回空列表
+	hits := []purify.Hit{}
+	if tp, ok := p.(*parser.TXTParser); ok {
+		h, err := tp.PurifyHits(book, chapterID)
+		if err != nil {
+			log.Printf("purifyHits error: %v", err)
+			http.Error(w, `{"error":"failed to read chapter"}`, http.StatusInternalServerError)
+			return
+		}
+		if h != nil {
+			hits = h
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"rules": hits})
+}
+
 func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
 	// /api/book/cover/{hash}
 	hash := strings.TrimPrefix(r.URL.Path, "/api/book/cover/")
@@ -155,6 +467,23 @@ func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, book.CoverFilePath)
 }
 
+func (s *Server) handleOriginal(w http.ResponseWriter, r *http.Request) {
+	// /api/book/original/{hash}
+	hash := strings.TrimPrefix(r.URL.Path, "/api/book/original/")
+	if hash == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	book, ok := s.cache.Get(hash)
+	if !ok || book.SourceFile == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, book.SourceFile)
+}
+
 func (s *Server) handleResource(w http.ResponseWriter, r *http.Request) {
 	// /api/book/resource/{hash}/{path...}
 	rest := strings.TrimPrefix(r.URL.Path, "/api/book/resource/")
@@ -193,6 +522,11 @@ func (s *Server) handleResource(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, fullPath)
 }
 
+func (s *Server) handleFormats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"formats": parser.Formats()})
+}
+
 // findCover 在缓存目录中查找封面图片
 func findCover(cachePath string) string {
 	var found string